@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthNone     = "none"
+	AuthBasic    = "basic"
+	AuthBearer   = "bearer"
+	AuthOAuth2   = "oauth2"
+	AuthAccounts = "accounts"
+)
+
+// AuthProvider adds the credentials of a simulated user to a request.
+// The user is identified by the client ip of the log.
+type AuthProvider interface {
+	Authenticate(request *http.Request, user string) error
+}
+
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (ba *BasicAuth) Authenticate(request *http.Request, user string) error {
+	request.SetBasicAuth(ba.Username, ba.Password)
+	return nil
+}
+
+type BearerToken struct {
+	Token string
+}
+
+func (bt *BearerToken) Authenticate(request *http.Request, user string) error {
+	request.Header.Set("Authorization", "Bearer "+bt.Token)
+	return nil
+}
+
+// OAuth2ClientCredentials fetches an access token with the client credentials grant
+// and refreshes it shortly before it expires.
+type OAuth2ClientCredentials struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+	mutex        *sync.Mutex
+	token        string
+	expiry       time.Time
+}
+
+func NewOAuth2ClientCredentials(client *http.Client, tokenURL, clientID, clientSecret string, scopes []string) (*OAuth2ClientCredentials, error) {
+	if tokenURL == "" || clientID == "" {
+		return nil, errors.New("oauth2 needs a token url and a client id")
+	}
+	return &OAuth2ClientCredentials{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       splitValues(scopes),
+		client:       client,
+		mutex:        &sync.Mutex{},
+	}, nil
+}
+
+func (oc *OAuth2ClientCredentials) Authenticate(request *http.Request, user string) error {
+	token, err := oc.Token()
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns the current access token and fetches a new one, if needed.
+func (oc *OAuth2ClientCredentials) Token() (string, error) {
+	oc.mutex.Lock()
+	defer oc.mutex.Unlock()
+
+	if oc.token != "" && time.Now().Before(oc.expiry) {
+		return oc.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(oc.scopes) > 0 {
+		form.Set("scope", strings.Join(oc.scopes, " "))
+	}
+	request, err := http.NewRequest("POST", oc.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(oc.clientID), url.QueryEscape(oc.clientSecret))
+
+	resp, err := oc.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("error fetching oauth2 token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error fetching oauth2 token: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("error fetching oauth2 token: status %v: %s", resp.StatusCode, body)
+	}
+
+	tokenResponse := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", fmt.Errorf("error parsing oauth2 token response: %v", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", errors.New("oauth2 token response contains no access_token")
+	}
+
+	oc.token = tokenResponse.AccessToken
+	oc.expiry = time.Now().Add(time.Hour)
+	if tokenResponse.ExpiresIn > 0 {
+		// refresh a bit earlier, so that no request is sent with an expired token
+		validity := time.Duration(tokenResponse.ExpiresIn) * time.Second
+		oc.expiry = time.Now().Add(validity - validity/10)
+	}
+	return oc.token, nil
+}
+
+// AccountPool assigns the test accounts round robin to the simulated users,
+// so that every user keeps its own credentials.
+type AccountPool struct {
+	accounts []AuthProvider
+	mutex    *sync.Mutex
+	assigned map[string]AuthProvider
+	next     int
+}
+
+// LoadAccountPool reads one account per line, either as 'username:password' for basic auth
+// or as 'Bearer <token>'. Empty lines and lines starting with # are skipped.
+func LoadAccountPool(fileName string) (*AccountPool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pool := &AccountPool{
+		mutex:    &sync.Mutex{},
+		assigned: make(map[string]AuthProvider),
+	}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "Bearer ") {
+			pool.accounts = append(pool.accounts, &BearerToken{Token: strings.TrimSpace(line[len("Bearer "):])})
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%v:%v: expected 'username:password' or 'Bearer <token>'", fileName, lineNumber)
+		}
+		pool.accounts = append(pool.accounts, &BasicAuth{Username: parts[0], Password: parts[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pool.accounts) == 0 {
+		return nil, fmt.Errorf("no accounts found in %v", fileName)
+	}
+	return pool, nil
+}
+
+func (ap *AccountPool) Authenticate(request *http.Request, user string) error {
+	return ap.accountFor(user).Authenticate(request, user)
+}
+
+func (ap *AccountPool) accountFor(user string) AuthProvider {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+
+	account, exist := ap.assigned[user]
+	if !exist {
+		account = ap.accounts[ap.next%len(ap.accounts)]
+		ap.next++
+		ap.assigned[user] = account
+	}
+	return account
+}
+
+func newAuthProvider(args *Args, clientFactory *ClientFactory) (AuthProvider, error) {
+	kind := args.Auth
+	if kind == "" {
+		kind = AuthNone
+		if args.Username != "" {
+			kind = AuthBasic
+		}
+	}
+
+	switch kind {
+	case AuthNone:
+		return nil, nil
+	case AuthBasic:
+		return &BasicAuth{Username: args.Username, Password: args.Password}, nil
+	case AuthBearer:
+		if args.BearerToken == "" {
+			return nil, errors.New("--auth bearer needs a --bearer-token")
+		}
+		return &BearerToken{Token: args.BearerToken}, nil
+	case AuthOAuth2:
+		oauth2, err := NewOAuth2ClientCredentials(clientFactory.NewClient(), args.OAuth2URL, args.OAuth2ID, args.OAuth2Secret, args.OAuth2Scope)
+		if err != nil {
+			return nil, err
+		}
+		return oauth2, nil
+	case AuthAccounts:
+		pool, err := LoadAccountPool(args.AccountsFile)
+		if err != nil {
+			return nil, err
+		}
+		return pool, nil
+	}
+	return nil, fmt.Errorf("unknown auth %q", kind)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test_OAuth2ClientCredentials(t *testing.T) {
+	a := assert.New(t)
+
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		id, secret, _ := r.BasicAuth()
+		if id != "bench" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte(`{"access_token": "token-1", "token_type": "bearer", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	oauth2, err := NewOAuth2ClientCredentials(http.DefaultClient, server.URL, "bench", "s3cret", []string{"read", "write"})
+	a.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		a.NoError(oauth2.Authenticate(request, "1.2.3.4"))
+		a.Equal("Bearer token-1", request.Header.Get("Authorization"))
+	}
+	a.Equal(1, tokenRequests)
+
+	oauth2, err = NewOAuth2ClientCredentials(http.DefaultClient, server.URL, "bench", "wrong", []string{"read", "write"})
+	a.NoError(err)
+	request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	a.Error(oauth2.Authenticate(request, "1.2.3.4"))
+}
+
+func Test_AccountPool(t *testing.T) {
+	a := assert.New(t)
+
+	file, err := ioutil.TempFile("", "accounts")
+	a.NoError(err)
+	defer os.Remove(file.Name())
+	file.WriteString("# test accounts\nalice:pw1\n\nBearer abc\n")
+	file.Close()
+
+	pool, err := LoadAccountPool(file.Name())
+	a.NoError(err)
+
+	authorization := func(user string) string {
+		request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		a.NoError(pool.Authenticate(request, user))
+		return request.Header.Get("Authorization")
+	}
+
+	first := authorization("1.1.1.1")
+	second := authorization("2.2.2.2")
+	a.NotEqual(first, second)
+	a.Equal(first, authorization("1.1.1.1"))
+	a.Equal(second, authorization("2.2.2.2"))
+	a.Equal(first, authorization("3.3.3.3"))
+	a.Equal("Bearer abc", second)
+}
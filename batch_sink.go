@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchOptions configure how a BatchSink collects the entries.
+type BatchOptions struct {
+	// BatchSize is the maximum number of entries per batch
+	BatchSize int
+	// FlushInterval is the maximum time to collect the entries of a batch
+	FlushInterval time.Duration
+	// Workers sending batches in parallel
+	Workers int
+	// Unreplayed entries are queued as well, e.g. to index a log without replaying it
+	Unreplayed bool
+}
+
+// BatchSink collects the entries and passes them in batches to the send function,
+// which returns how many entries of the batch were delivered.
+// It is embedded by the outputs, like the indexer and the metric sinks.
+type BatchSink struct {
+	name       string
+	options    BatchOptions
+	send       func(ctx context.Context, batch []*LogEntry) (int, error)
+	fanout     chan *LogEntry
+	ctx        context.Context
+	cancel     context.CancelFunc
+	workers    *sync.WaitGroup
+	workerDone chan struct{}
+	sent       int64
+	failed     int64
+}
+
+func NewBatchSink(name string, options BatchOptions, send func(ctx context.Context, batch []*LogEntry) (int, error)) *BatchSink {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 1000
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bs := &BatchSink{
+		name:       name,
+		options:    options,
+		send:       send,
+		fanout:     make(chan *LogEntry, 100),
+		ctx:        ctx,
+		cancel:     cancel,
+		workers:    &sync.WaitGroup{},
+		workerDone: make(chan struct{}),
+	}
+	for i := 0; i < options.Workers; i++ {
+		bs.workers.Add(1)
+		go bs.startWorker()
+	}
+	go func() {
+		bs.workers.Wait()
+		close(bs.workerDone)
+	}()
+	return bs
+}
+
+// Process queues the entries, after they were replayed.
+func (bs *BatchSink) Process(ctx context.Context, l *LogEntry) error {
+	l.wg.Wait()
+	if l.ContentType == "ignore" || (!bs.options.Unreplayed && l.Replay.Status == 0 && !l.Replay.Error) {
+		return nil
+	}
+	select {
+	case bs.fanout <- l:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bs *BatchSink) startWorker() {
+	defer bs.workers.Done()
+	for {
+		flushTimeout := time.After(bs.options.FlushInterval)
+		closed := false
+		batch := []*LogEntry{}
+	aggregate:
+		for len(batch) < bs.options.BatchSize {
+			select {
+			case l, ok := <-bs.fanout:
+				if !ok {
+					closed = true
+					break aggregate
+				}
+				batch = append(batch, l)
+			case <-flushTimeout:
+				break aggregate
+			}
+		}
+		if len(batch) > 0 {
+			sent, err := bs.send(bs.ctx, batch)
+			atomic.AddInt64(&bs.sent, int64(sent))
+			atomic.AddInt64(&bs.failed, int64(len(batch)-sent))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error sending %v entries to %v: %v\n", len(batch)-sent, bs.name, err)
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// Finish sends the queued entries. If the context is done before, the sending is aborted.
+func (bs *BatchSink) Finish(ctx context.Context) error {
+	return bs.finish(ctx, nil)
+}
+
+// finish calls abort additionally to canceling the context of the send function, if the context is done.
+func (bs *BatchSink) finish(ctx context.Context, abort func()) error {
+	close(bs.fanout)
+	return waitOrCancel(ctx, bs.workerDone, func() {
+		bs.cancel()
+		if abort != nil {
+			abort()
+		}
+	})
+}
+
+// counts returns the number of sent and failed entries.
+func (bs *BatchSink) counts() (sent, failed int64) {
+	return atomic.LoadInt64(&bs.sent), atomic.LoadInt64(&bs.failed)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const diffContext = 60
+
+// BodyComparer compares the response bodies of two targets,
+// after removing the parts which are expected to differ.
+type BodyComparer struct {
+	ignorePaths [][]string
+	masks       []*regexp.Regexp
+}
+
+// NewBodyComparer creates a comparer which removes the given json paths (e.g. 'meta.requestId' or 'items.*.updated')
+// and replaces all matches of the mask patterns.
+func NewBodyComparer(ignoreJSONPaths, maskRegex []string) (*BodyComparer, error) {
+	bc := &BodyComparer{}
+	for _, p := range splitValues(ignoreJSONPaths) {
+		bc.ignorePaths = append(bc.ignorePaths, strings.Split(p, "."))
+	}
+	for _, m := range maskRegex {
+		r, err := regexp.Compile(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask %q: %v", m, err)
+		}
+		bc.masks = append(bc.masks, r)
+	}
+	return bc, nil
+}
+
+// Normalize returns the body without ignored json paths and with masked patterns.
+// JSON bodies are formatted with sorted keys, so that the key order does not matter.
+func (bc *BodyComparer) Normalize(body []byte) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err == nil {
+		for _, p := range bc.ignorePaths {
+			doc = removePath(doc, p)
+		}
+		if formatted, err := json.MarshalIndent(doc, "", " "); err == nil {
+			body = formatted
+		}
+	}
+	for _, m := range bc.masks {
+		body = m.ReplaceAll(body, []byte("***"))
+	}
+	return body
+}
+
+// Diff returns an excerpt around the first difference of the normalized bodies,
+// or an empty string if they are equal.
+func (bc *BodyComparer) Diff(reference, candidate []byte) string {
+	reference, candidate = bc.Normalize(reference), bc.Normalize(candidate)
+	if bytes.Equal(reference, candidate) {
+		return ""
+	}
+
+	i := 0
+	for i < len(reference) && i < len(candidate) && reference[i] == candidate[i] {
+		i++
+	}
+	line := bytes.Count(reference[:i], []byte("\n")) + 1
+	return fmt.Sprintf("line %v: -%q +%q", line, excerpt(reference, i), excerpt(candidate, i))
+}
+
+func excerpt(body []byte, pos int) string {
+	start, end := pos-diffContext/2, pos+diffContext
+	if start < 0 {
+		start = 0
+	}
+	if end > len(body) {
+		end = len(body)
+	}
+	if start > end {
+		start = end
+	}
+	return string(body[start:end])
+}
+
+func removePath(doc interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return doc
+	}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			if path[0] == "*" {
+				return map[string]interface{}{}
+			}
+			delete(v, path[0])
+			return v
+		}
+		for k, child := range v {
+			if path[0] == "*" || path[0] == k {
+				v[k] = removePath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		if path[0] != "*" {
+			return v
+		}
+		if len(path) == 1 {
+			return []interface{}{}
+		}
+		for i, child := range v {
+			v[i] = removePath(child, path[1:])
+		}
+	}
+	return doc
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_BodyComparer_JSON(t *testing.T) {
+	a := assert.New(t)
+
+	bc, err := NewBodyComparer([]string{"meta.requestId", "items.*.updated"}, nil)
+	a.NoError(err)
+
+	reference := []byte(`{"meta": {"requestId": "a1", "page": 1}, "items": [{"id": 1, "updated": "2016-05-29"}, {"id": 2, "updated": "2016-05-30"}]}`)
+	candidate := []byte(`{"items": [{"updated": "2018-01-01", "id": 1}, {"id": 2}], "meta": {"page": 1, "requestId": "b2"}}`)
+	a.Equal("", bc.Diff(reference, candidate))
+
+	candidate = []byte(`{"items": [{"id": 1}, {"id": 3}], "meta": {"page": 1}}`)
+	diff := bc.Diff(reference, candidate)
+	a.Contains(diff, "line 7")
+	a.Contains(diff, `"id\": 2`)
+	a.Contains(diff, `"id\": 3`)
+}
+
+func Test_BodyComparer_Masks(t *testing.T) {
+	a := assert.New(t)
+
+	bc, err := NewBodyComparer(nil, []string{`\d{2}:\d{2}:\d{2}`, `csrf-[a-z0-9]+`})
+	a.NoError(err)
+
+	a.Equal("", bc.Diff([]byte("<p>12:00:01 csrf-abc</p>"), []byte("<p>13:14:15 csrf-x42</p>")))
+	a.NotEqual("", bc.Diff([]byte("<p>Hello</p>"), []byte("<p>Hallo</p>")))
+
+	_, err = NewBodyComparer(nil, []string{"("})
+	a.Error(err)
+}
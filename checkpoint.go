@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Checkpoint is the position in every input before the first entry, which is not processed yet.
+type Checkpoint struct {
+	Files []FilePosition
+	// Timestamp of the last processed entry in the log
+	Timestamp time.Time
+	// Lag of the replay behind the log time, when the checkpoint was written
+	Lag     time.Duration
+	Written time.Time
+}
+
+type FilePosition struct {
+	FileName string
+	Line     int64
+	// Offset in bytes of the uncompressed content
+	Offset int64
+}
+
+// resumePoint is the checkpoint to continue from with --resume.
+var resumePoint *Checkpoint
+
+// loadCheckpoint returns nil, if the file does not exist.
+func loadCheckpoint(fileName string) (*Checkpoint, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(content, cp); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %v: %v", fileName, err)
+	}
+	return cp, nil
+}
+
+// matches checks, that the checkpoint belongs to the given log files.
+func (cp *Checkpoint) matches(logFiles []string) error {
+	if len(logFiles) == 0 {
+		return fmt.Errorf("can not resume reading from stdin")
+	}
+	if len(cp.Files) != len(logFiles) {
+		return fmt.Errorf("checkpoint is for %v log files, not %v", len(cp.Files), len(logFiles))
+	}
+	for i, f := range cp.Files {
+		if f.FileName != logFiles[i] {
+			return fmt.Errorf("checkpoint is for %v, which is not log file number %v", f.FileName, i+1)
+		}
+	}
+	return nil
+}
+
+// CheckpointWriter writes the position periodically into a file.
+// The methods do nothing on a nil writer.
+type CheckpointWriter struct {
+	fileName string
+	interval time.Duration
+	position Checkpoint
+	saved    time.Time
+}
+
+// NewCheckpointWriter starts at the given position. It returns nil, if no file is given.
+func NewCheckpointWriter(fileName string, interval time.Duration, start Checkpoint) *CheckpointWriter {
+	if fileName == "" {
+		return nil
+	}
+	return &CheckpointWriter{
+		fileName: fileName,
+		interval: interval,
+		position: start,
+		saved:    time.Now(),
+	}
+}
+
+// Due reports whether the interval has passed, so that the position should be updated.
+func (cw *CheckpointWriter) Due() bool {
+	return cw != nil && time.Since(cw.saved) >= cw.interval
+}
+
+// Set sets the current position without saving it.
+func (cw *CheckpointWriter) Set(position Checkpoint) {
+	if cw != nil {
+		cw.position = position
+	}
+}
+
+// Update sets the current position and saves it, if the interval has passed.
+func (cw *CheckpointWriter) Update(position Checkpoint) error {
+	if cw == nil {
+		return nil
+	}
+	cw.position = position
+	if !cw.Due() {
+		return nil
+	}
+	return cw.Save()
+}
+
+// Save writes the current position into a temporary file and renames it,
+// so that a crash never leaves a partial checkpoint.
+func (cw *CheckpointWriter) Save() error {
+	if cw == nil {
+		return nil
+	}
+	cw.saved = time.Now()
+	cw.position.Written = cw.saved
+	if !cw.position.Timestamp.IsZero() {
+		cw.position.Lag = cw.saved.Sub(cw.position.Timestamp)
+	}
+	js, err := json.MarshalIndent(cw.position, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := cw.fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(js, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cw.fileName)
+}
+
+// initResume loads the --checkpoint file for --resume.
+// Without a checkpoint file, the input is read from the beginning.
+func initResume(args *Args) error {
+	if !args.Resume {
+		return nil
+	}
+	if args.Checkpoint == "" {
+		return fmt.Errorf("--resume needs a --checkpoint file")
+	}
+	cp, err := loadCheckpoint(args.Checkpoint)
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		fmt.Fprintf(os.Stderr, "no checkpoint in %v, starting from the beginning\n", args.Checkpoint)
+		return nil
+	}
+	if err := cp.matches(args.LogFiles); err != nil {
+		return err
+	}
+	resumePoint = cp
+	for _, f := range cp.Files {
+		fmt.Fprintf(os.Stderr, "resuming %v after line %v\n", f.FileName, f.Line)
+	}
+	return nil
+}
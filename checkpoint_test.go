@@ -0,0 +1,193 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingProcessor struct {
+	requests []string
+	stopAt   string
+	cancel   context.CancelFunc
+}
+
+func (rp *recordingProcessor) Process(ctx context.Context, l *LogEntry) error {
+	if l.Request == rp.stopAt {
+		rp.cancel()
+		return ctx.Err()
+	}
+	rp.requests = append(rp.requests, l.Request)
+	return nil
+}
+
+func Test_Checkpoint_Resume(t *testing.T) {
+	for _, name := range []string{"access.log", "access.log.gz"} {
+		t.Run(name, func(t *testing.T) {
+			a := assert.New(t)
+			defer func(a *Args) { args = a }(args)
+			args = defaultArgs()
+
+			dir, err := ioutil.TempDir("", "checkpoint")
+			a.NoError(err)
+			defer os.RemoveAll(dir)
+			logFile := filepath.Join(dir, name)
+			writeTestLog(t, logFile, 10)
+
+			// stop while processing the 7th entry
+			checkpointFile := filepath.Join(dir, "checkpoint.json")
+			ctx, cancel := context.WithCancel(context.Background())
+			first := &recordingProcessor{stopAt: "/6", cancel: cancel}
+			in, file, err := openLog(logFile, 0)
+			a.NoError(err)
+			checkpoints := NewCheckpointWriter(checkpointFile, time.Hour, Checkpoint{})
+			merger := NewLogMerger([]*logSource{newLogSource(0, in, FilePosition{FileName: logFile})}, 0)
+			read(ctx, merger, first, checkpoints)
+			file.Close()
+			a.NoError(merger.WaitCompleted(context.Background()))
+			checkpoints.Set(merger.Checkpoint())
+			a.NoError(checkpoints.Save())
+			a.Equal([]string{"/0", "/1", "/2", "/3", "/4", "/5"}, first.requests)
+
+			cp, err := loadCheckpoint(checkpointFile)
+			a.NoError(err)
+			a.NoError(cp.matches([]string{logFile}))
+			a.Equal(int64(6), cp.Files[0].Line)
+			a.Equal("2016-05-29T13:00:05+02:00", cp.Timestamp.Format(time.RFC3339))
+
+			second := &recordingProcessor{}
+			in, file, err = openLog(logFile, cp.Files[0].Offset)
+			a.NoError(err)
+			defer file.Close()
+			merger = NewLogMerger([]*logSource{newLogSource(0, in, cp.Files[0])}, 0)
+			read(context.Background(), merger, second, nil)
+			a.NoError(merger.WaitCompleted(context.Background()))
+			a.Equal([]string{"/6", "/7", "/8", "/9"}, second.requests)
+			a.Equal(int64(10), merger.Checkpoint().Files[0].Line)
+		})
+	}
+}
+
+// delayedProcessor completes the replay of the entry with the delayed request, when it is released.
+type delayedProcessor struct {
+	delayed string
+	release chan struct{}
+}
+
+func (dp *delayedProcessor) Process(ctx context.Context, l *LogEntry) error {
+	if l.Request == dp.delayed {
+		l.wg.Add(1)
+		go func() {
+			<-dp.release
+			l.wg.Done()
+		}()
+	}
+	return nil
+}
+
+func Test_Checkpoint_DelayedReplay(t *testing.T) {
+	a := assert.New(t)
+	defer func(a *Args) { args = a }(args)
+	args = defaultArgs()
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	writeTestLog(t, logFile, 10)
+	in, file, err := openLog(logFile, 0)
+	a.NoError(err)
+	defer file.Close()
+
+	processor := &delayedProcessor{delayed: "/3", release: make(chan struct{})}
+	merger := NewLogMerger([]*logSource{newLogSource(0, in, FilePosition{FileName: logFile})}, 0)
+	count, _ := read(context.Background(), merger, processor, nil)
+	a.Equal(10, count)
+
+	// the checkpoint stays before the entry, which replay is not completed
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a.Error(merger.WaitCompleted(ctx))
+	cp := merger.Checkpoint()
+	a.Equal(int64(3), cp.Files[0].Line)
+	a.Equal("2016-05-29T13:00:02+02:00", cp.Timestamp.Format(time.RFC3339))
+
+	close(processor.release)
+	a.NoError(merger.WaitCompleted(context.Background()))
+	cp = merger.Checkpoint()
+	a.Equal(int64(10), cp.Files[0].Line)
+	a.Equal("2016-05-29T13:00:09+02:00", cp.Timestamp.Format(time.RFC3339))
+}
+
+func Test_Checkpoint_ResumeMultipleFiles(t *testing.T) {
+	a := assert.New(t)
+	defer func(a *Args, cp *Checkpoint) { args, resumePoint = a, cp }(args, resumePoint)
+	args = defaultArgs()
+
+	dir := t.TempDir()
+	args.LogFiles = []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log.gz")}
+	writeTestLog(t, args.LogFiles[0], 10)
+	writeTestLog(t, args.LogFiles[1], 10)
+	args.Checkpoint = filepath.Join(dir, "checkpoint.json")
+	args.Output = filepath.Join(dir, "results.jsonl")
+
+	run := func() *recordingProcessor {
+		a.NoError(initResume(args))
+		pipeline, err := NewPipeline([]string{"file"}, args)
+		a.NoError(err)
+		recording := &recordingProcessor{}
+		process(append(CompoundProcessor{recording}, pipeline...))
+		return recording
+	}
+
+	// stop after the 7th entry, the entries of both files are merged by time
+	args.Limit = 7
+	first := run()
+	a.Equal([]string{"/0", "/0", "/1", "/1", "/2", "/2", "/3"}, first.requests)
+
+	args.Limit = defaultArgs().Limit
+	args.Resume = true
+	second := run()
+	a.Equal([]string{"/3", "/4", "/4", "/5", "/5", "/6", "/6", "/7", "/7", "/8", "/8", "/9", "/9"}, second.requests)
+
+	// the results of the first run are continued
+	content, err := ioutil.ReadFile(args.Output)
+	a.NoError(err)
+	a.Equal(20, strings.Count(string(content), "\n"))
+}
+
+func Test_Checkpoint_Matches(t *testing.T) {
+	a := assert.New(t)
+	cp := &Checkpoint{Files: []FilePosition{{FileName: "a.log"}, {FileName: "b.log"}}}
+	a.NoError(cp.matches([]string{"a.log", "b.log"}))
+	a.Error(cp.matches([]string{"b.log"}))
+	a.Error(cp.matches([]string{"a.log", "c.log"}))
+	a.Error(cp.matches(nil))
+
+	missing, err := loadCheckpoint("does-not-exist.json")
+	a.NoError(err)
+	a.Nil(missing)
+}
+
+func writeTestLog(t *testing.T, fileName string, lines int) {
+	b := &strings.Builder{}
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(b, "www.example.org 42.24.24.24 2016-05-29T13:00:%02d+0200 \"GET /%v HTTP/1.1\" 200 65536\n", i, i)
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if strings.HasSuffix(fileName, ".gz") {
+		gz := gzip.NewWriter(file)
+		defer gz.Close()
+		gz.Write([]byte(b.String()))
+		return
+	}
+	file.Write([]byte(b.String()))
+}
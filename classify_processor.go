@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"regexp"
+)
+
+func init() {
+	RegisterProcessor("classify", "set the content type of the entries by the --regex-* options", func(args *Args) (Processor, error) {
+		return NewClassifyProcessor(args.RegexIgnore, args.RegexAssets, args.RegexAjax, args.RegexSearch)
+	})
+}
+
+type ClassifyProcessor struct {
+	regexIgnore *regexp.Regexp
+	regexAssets *regexp.Regexp
+	regexAjax   *regexp.Regexp
+	regexSearch *regexp.Regexp
+}
+
+func NewClassifyProcessor(regexIgnore, regexAssets, regexAjax, regexSearch string) (*ClassifyProcessor, error) {
+	cp := &ClassifyProcessor{}
+	var err error
+	if cp.regexIgnore, err = regexp.Compile(regexIgnore); err != nil {
+		return nil, err
+	}
+	if cp.regexAssets, err = regexp.Compile(regexAssets); err != nil {
+		return nil, err
+	}
+	if cp.regexAjax, err = regexp.Compile(regexAjax); err != nil {
+		return nil, err
+	}
+	if cp.regexSearch, err = regexp.Compile(regexSearch); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *ClassifyProcessor) Process(ctx context.Context, l *LogEntry) error {
+	if cp.regexIgnore.MatchString(l.Request) {
+		l.ContentType = "ignore"
+	} else if cp.regexAssets.MatchString(l.Request) {
+		l.ContentType = "asset"
+	} else if cp.regexSearch.MatchString(l.Request) {
+		l.ContentType = "search"
+	} else if cp.regexAjax.MatchString(l.Request) {
+		l.ContentType = "ajax"
+	} else {
+		l.ContentType = "page"
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"os"
+	"strings"
+)
+
+type Command struct {
+	Name        string
+	Description string
+	Run         func(p *arg.Parser)
+}
+
+const inputPipeline = "classify,filter,rewrite,sample"
+
+var commands = []*Command{
+	{
+		Name:        "replay",
+		Description: "replay the log against the base url and write the results to the given outputs",
+		Run: runPipeline(func() string {
+			return inputPipeline + ",pace,replay" + sinks() + metricSinks() + ",errors,compare"
+		}),
+	},
+	{
+		Name:        "analyze",
+		Description: "print statistics of the log, without calling anything",
+		Run: runPipeline(func() string {
+			return inputPipeline + ",count"
+		}),
+	},
+	{
+		Name:        "index",
+		Description: "ship the log entries to elasticsearch logstash or a file, without replaying",
+		Run:         runIndex,
+	},
+	{
+		Name:        "convert",
+		Description: "write the parsed log entries as json lines to stdout or the --output file",
+		Run: runPipeline(func() string {
+			if args.Output != "" {
+				return inputPipeline + ",file"
+			}
+			return inputPipeline + ",jsonl"
+		}),
+	},
+	{
+		Name:        "processors",
+		Description: "list the processors available for the --pipeline option",
+		Run:         runListProcessors,
+	},
+	{
+		Name:        "print-config",
+		Description: "print the effective configuration in the format of the config file",
+		Run:         runPrintConfig,
+	},
+}
+
+func findCommand(name string) (*Command, bool) {
+	for _, c := range commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Description is shown by go-arg on top of the help.
+func (Args) Description() string {
+	b := &strings.Builder{}
+	b.WriteString("Commands (replay is used, if none is given):\n")
+	for _, c := range commands {
+		fmt.Fprintf(b, "  %-14v %v\n", c.Name, c.Description)
+	}
+	return b.String()
+}
+
+// sinks are the processors for the given outputs.
+func sinks() string {
+	sinks := ""
+	if args.EsURL != "" {
+		sinks += ",es"
+	}
+	if args.LogstashAddr != "" {
+		sinks += ",logstash"
+	}
+	if args.Output != "" {
+		sinks += ",file"
+	}
+	return sinks
+}
+
+// metricSinks are the processors for the metric outputs, which only get the replayed entries.
+func metricSinks() string {
+	sinks := ""
+	if args.InfluxURL != "" {
+		sinks += ",influx"
+	}
+	if args.StatsdAddr != "" {
+		sinks += ",statsd"
+	}
+	if args.OTLPURL != "" {
+		sinks += ",otlp"
+	}
+	return sinks
+}
+
+// runPipeline processes the log with the --pipeline option or the default pipeline of the command.
+func runPipeline(defaultPipeline func() string) func(p *arg.Parser) {
+	return func(p *arg.Parser) {
+		pipeline := args.Pipeline
+		if pipeline == "" {
+			pipeline = defaultPipeline()
+		}
+		processors, err := NewPipeline([]string{pipeline}, args)
+		if err != nil {
+			p.Fail(err.Error())
+		}
+		process(processors)
+	}
+}
+
+func runIndex(p *arg.Parser) {
+	if sinks() == "" && args.Pipeline == "" {
+		p.Fail("index needs an --es-url or --logstash or --output")
+	}
+	runPipeline(func() string {
+		return inputPipeline + sinks()
+	})(p)
+}
+
+func runListProcessors(p *arg.Parser) {
+	printProcessors(os.Stdout)
+}
+
+func runPrintConfig(p *arg.Parser) {
+	if err := printConfig(os.Stdout, args); err != nil {
+		p.Fail(err.Error())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+func init() {
+	RegisterProcessor("compare", "print the differences between the base url and the candidate url per request", func(args *Args) (Processor, error) {
+		return NewComparisonProcessor(), nil
+	})
+}
+
+type comparison struct {
+	request          string
+	count            int
+	primaryMs        int
+	candidateMs      int
+	primaryErrors    int
+	candidateErrors  int
+	statusMismatches int
+	bodyMismatches   int
+}
+
+// ComparisonProcessor aggregates the results of a shadow replay per url,
+// to show the latency, status and body differences between the two targets.
+type ComparisonProcessor struct {
+	mutex       *sync.Mutex
+	comparisons map[string]*comparison
+}
+
+func NewComparisonProcessor() *ComparisonProcessor {
+	return &ComparisonProcessor{
+		mutex:       &sync.Mutex{},
+		comparisons: make(map[string]*comparison),
+	}
+}
+
+func (cp *ComparisonProcessor) Process(ctx context.Context, l *LogEntry) error {
+	l.wg.Wait()
+	if l.ContentType == "ignore" || l.Replay.Candidate == nil {
+		return nil
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	c, exist := cp.comparisons[l.Request]
+	if !exist {
+		c = &comparison{request: l.Request}
+		cp.comparisons[l.Request] = c
+	}
+	c.count++
+	c.primaryMs += l.Replay.DurationMs
+	c.candidateMs += l.Replay.Candidate.DurationMs
+	if l.Replay.Error {
+		c.primaryErrors++
+	}
+	if l.Replay.Candidate.Error {
+		c.candidateErrors++
+	}
+	if l.Replay.Candidate.StatusMismatch {
+		c.statusMismatches++
+	}
+	if l.Replay.Candidate.BodyMismatch {
+		c.bodyMismatches++
+	}
+	return nil
+}
+
+func (cp *ComparisonProcessor) PrintResults(w io.Writer) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if len(cp.comparisons) == 0 {
+		return
+	}
+	list := make([]*comparison, 0, len(cp.comparisons))
+	total := &comparison{request: "total"}
+	for _, c := range cp.comparisons {
+		list = append(list, c)
+		total.count += c.count
+		total.primaryMs += c.primaryMs
+		total.candidateMs += c.candidateMs
+		total.primaryErrors += c.primaryErrors
+		total.candidateErrors += c.candidateErrors
+		total.statusMismatches += c.statusMismatches
+		total.bodyMismatches += c.bodyMismatches
+	}
+	// mismatches first, then the biggest slowdowns
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].statusMismatches != list[j].statusMismatches {
+			return list[i].statusMismatches > list[j].statusMismatches
+		}
+		if list[i].bodyMismatches != list[j].bodyMismatches {
+			return list[i].bodyMismatches > list[j].bodyMismatches
+		}
+		return list[i].avgDiffMs() > list[j].avgDiffMs()
+	})
+
+	fmt.Fprintf(w, "%8v %12v %12v %10v %11v %11v %10v %v\n", "count", "primary ms", "candidate ms", "diff ms", "status diff", "body diff", "errors", "request")
+	for _, c := range append(list, total) {
+		if c.count == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%8v %12.1f %12.1f %+10.1f %11v %11v %4v/%-5v %v\n",
+			c.count,
+			float64(c.primaryMs)/float64(c.count),
+			float64(c.candidateMs)/float64(c.count),
+			c.avgDiffMs(),
+			c.statusMismatches,
+			c.bodyMismatches,
+			c.primaryErrors,
+			c.candidateErrors,
+			c.request)
+	}
+}
+
+func (c *comparison) avgDiffMs() float64 {
+	if c.count == 0 {
+		return 0
+	}
+	return float64(c.candidateMs-c.primaryMs) / float64(c.count)
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"io"
 	"time"
 )
@@ -10,15 +11,18 @@ type ResultPrinter interface {
 	PrintResults(w io.Writer)
 }
 
+// Finisher is implemented by processors with own workers.
+// Finish processes the queued entries and stops the workers.
+// If the context is done before, the work in progress is canceled.
 type Finisher interface {
-	Finish() chan bool
+	Finish(ctx context.Context) error
 }
 
 type CompoundProcessor []Processor
 
-func (cp CompoundProcessor) Process(l *LogEntry) error {
+func (cp CompoundProcessor) Process(ctx context.Context, l *LogEntry) error {
 	for _, p := range cp {
-		if err := p.Process(l); err != nil {
+		if err := p.Process(ctx, l); err != nil {
 			return err
 		}
 	}
@@ -34,23 +38,40 @@ func (cp CompoundProcessor) PrintResults(w io.Writer) {
 	}
 }
 
-func (cp CompoundProcessor) Finish(timeout time.Duration) error {
-	finisher := make([]chan bool, 0)
+// Finish finishes the processors in the order of the pipeline,
+// so that e.g. the indexer gets the entries of the finished replay.
+// If a processor used up the context, the following ones get a new one with the flush timeout,
+// so that the outputs can still send the entries of the aborted replay.
+func (cp CompoundProcessor) Finish(ctx context.Context, flushTimeout time.Duration) error {
+	var firstErr error
 	for _, p := range cp {
 		fi, ok := p.(Finisher)
 		if ok {
-			finisher = append(finisher, fi.Finish())
+			if ctx.Err() != nil {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(context.Background(), flushTimeout)
+				defer cancel()
+			}
+			if err := fi.Finish(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
 		}
 	}
-
-	timer := time.After(timeout)
-	for _, okChan := range finisher {
-		select {
-		case <-okChan:
-			// terminated, fine!
-		case <-timer:
-			return errors.New("error: not all jobs terminated")
-		}
+	if firstErr != nil {
+		return fmt.Errorf("error: not all jobs terminated: %v", firstErr)
 	}
 	return nil
 }
+
+// waitOrCancel waits until done is closed. If the context is done before,
+// cancel is called to abort the work in progress and it still waits for done.
+func waitOrCancel(ctx context.Context, done <-chan struct{}, cancel context.CancelFunc) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type finishFunc func(ctx context.Context) error
+
+func (f finishFunc) Process(ctx context.Context, l *LogEntry) error { return nil }
+
+func (f finishFunc) Finish(ctx context.Context) error { return f(ctx) }
+
+func Test_CompoundProcessor_FinishWithFlushTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	sinkFlushed := false
+	cp := CompoundProcessor{
+		finishFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		finishFunc(func(ctx context.Context) error {
+			sinkFlushed = ctx.Err() == nil
+			return nil
+		}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := cp.Finish(ctx, time.Second)
+	a.Error(err)
+	a.True(sinkFlushed)
+}
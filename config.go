@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type Args struct {
+	Config           string        `arg:"--config,help: Yaml file with options. Flags given on the command line take precedence"`
+	Pipeline         string        `arg:"--pipeline,help: Comma separated processors to use instead of the default pipeline of the command (see the processors command)"`
+	LogFiles         []string      `arg:"positional,help: The logfiles to replay"`
+	Verbose          bool          `arg:"-v,help: More verbose output"`
+	ShowErrors       bool          `arg:"--show-errors,help: Show errors"`
+	Limit            int           `arg:"--limit,help: Only process the first LIMIT lines"`
+	RegexIgnore      string        `arg:"--regex-ignore,help: Pattern for lines to ignore (matched against the request)"`
+	RegexAssets      string        `arg:"--regex-asset,help: Pattern for lines of type asset (matched against the request)"`
+	RegexAjax        string        `arg:"--regex-ajax,help: Pattern for lines of type ajax (matched against the request)"`
+	RegexSearch      string        `arg:"--regex-search,help: Pattern for lines of type search (matched against the request)"`
+	BaseUrl          string        `arg:"--base-url,help: The base url to call"`
+	Auth             string        `arg:"--auth,help: Authentication for the replay: none/basic/bearer/oauth2/accounts (default: basic if a username is given)"`
+	Username         string        `arg:"--username,help: Http Basic Auth Username"`
+	Password         string        `arg:"--password,help: Http Basic Auth Password"`
+	BearerToken      string        `arg:"--bearer-token,help: Static token for bearer auth"`
+	OAuth2URL        string        `arg:"--oauth2-token-url,help: Token endpoint for the oauth2 client credentials flow"`
+	OAuth2ID         string        `arg:"--oauth2-client-id,help: Client id for the oauth2 client credentials flow"`
+	OAuth2Secret     string        `arg:"--oauth2-client-secret,help: Client secret for the oauth2 client credentials flow"`
+	OAuth2Scope      []string      `arg:"--oauth2-scope,help: Scope to request in the oauth2 client credentials flow"`
+	AccountsFile     string        `arg:"--accounts-file,help: File with one account per simulated user as username:password or 'Bearer <token>' lines"`
+	EsURL            string        `arg:"--es-url,help: Index the entries in elasticsearch at this url (e.g. http://127.0.0.1:9200)"`
+	EsUsername       string        `arg:"--es-username,help: Basic auth username for elasticsearch"`
+	EsPassword       string        `arg:"--es-password,help: Basic auth password for elasticsearch"`
+	EsAPIKey         string        `arg:"--es-api-key,help: Api key for elasticsearch as id:key or base64 encoded"`
+	EsInsecure       bool          `arg:"--es-insecure,help: Don't verify the tls certificate of elasticsearch"`
+	EsCAFile         string        `arg:"--es-ca-file,help: Pem file with the ca certificates of elasticsearch"`
+	EsClientCert     string        `arg:"--es-client-cert,help: Pem file with a client certificate for elasticsearch"`
+	EsClientKey      string        `arg:"--es-client-key,help: Pem file with the key of the --es-client-cert"`
+	EsBatchSize      int           `arg:"--es-batch-size,help: Maximum number of documents per bulk request"`
+	EsFlushInterval  time.Duration `arg:"--es-flush-interval,help: Maximum time to collect the documents of a bulk request"`
+	EsWorkers        int           `arg:"--es-workers,help: Number of concurrent bulk requests"`
+	EsIndex          string        `arg:"--es-index,help: Index name pattern with a time layout of the log timestamp in curly braces"`
+	EsDataStream     string        `arg:"--es-data-stream,help: Write into this data stream instead of the --es-index"`
+	EsDeleteAfter    string        `arg:"--es-delete-after,help: Install an ilm policy deleting the indices after this age (e.g. 30d)"`
+	EsSkipTemplate   bool          `arg:"--es-skip-template,help: Don't install the index template with the mappings of the entries"`
+	EsMaxRetries     int           `arg:"--es-max-retries,help: Retries of documents rejected by elasticsearch with 429 or 5xx"`
+	EsDeadLetter     string        `arg:"--es-dead-letter,help: Json lines file for the documents which could not be indexed"`
+	EsTimeout        time.Duration `arg:"--es-timeout,help: Timeout of the requests to elasticsearch"`
+	LogstashAddr     string        `arg:"--logstash,help: Send the entries to logstash at udp://host:port or tcp://host:port (json lines) or beats://host:port (host:port means udp)"`
+	LogstashBatch    int           `arg:"--logstash-batch-size,help: Maximum number of entries sent to logstash at once"`
+	LogstashFlush    time.Duration `arg:"--logstash-flush-interval,help: Maximum time to collect the entries sent to logstash at once"`
+	InfluxURL        string        `arg:"--influx-url,help: Write the replay results as influxdb line protocol to this write url (e.g. http://127.0.0.1:8086/api/v2/write?org=o&bucket=b)"`
+	InfluxToken      string        `arg:"--influx-token,help: Token for influxdb 2.x"`
+	InfluxMeasure    string        `arg:"--influx-measurement,help: Measurement name of the points in influxdb"`
+	StatsdAddr       string        `arg:"--statsd,help: Send timers and counters per content type to statsd at host:port over udp"`
+	StatsdPrefix     string        `arg:"--statsd-prefix,help: Prefix of the statsd metric names"`
+	DogStatsD        bool          `arg:"--dogstatsd,help: Send the content type and status as dogstatsd tags instead of in the metric names"`
+	OTLPURL          string        `arg:"--otlp-url,help: Export metrics over otlp/http to this url (e.g. http://127.0.0.1:4318/v1/metrics)"`
+	OTLPHeader       []string      `arg:"--otlp-header,help: Header for the otlp export as 'Name: value'"`
+	MetricsFlush     time.Duration `arg:"--metrics-flush-interval,help: Interval of sending the metrics to influxdb statsd and otlp"`
+	Output           string        `arg:"--output,help: Write the entries as json lines into this file (gzip compressed if it ends with .gz)"`
+	OutputMaxMB      int           `arg:"--output-max-mb,help: Start a new --output file after this size in MB (uncompressed)"`
+	OutputInterval   time.Duration `arg:"--output-interval,help: Start a new --output file after this time"`
+	SampleBy         string        `arg:"--sample-by,help: Sample by 'session' (keeps whole user journeys) or by 'request'"`
+	SamplePercent    float64       `arg:"--sample-percent,help: Only replay this percentage of the sessions/requests"`
+	SampleRate       float64       `arg:"--sample-rate,help: Sample down to this target rate of requests per second"`
+	From             string        `arg:"--from,help: Only process entries logged at or after this time (e.g. 2016-05-29T18:00)"`
+	To               string        `arg:"--to,help: Only process entries logged before this time"`
+	Verb             []string      `arg:"--verb,help: Only process entries with this http verb"`
+	Status           []string      `arg:"--status,help: Only process entries with this response status (x as wildcard like 2xx) [default: 200]"`
+	ContentType      []string      `arg:"--content-type,help: Only process entries of this content type (page/asset/ajax/search)"`
+	ClientCIDR       []string      `arg:"--client-cidr,help: Only process entries with a client ip in this network"`
+	Host             []string      `arg:"--host,help: Only process entries for this host from absolute request urls or a virtual host field"`
+	PathPrefix       []string      `arg:"--path-prefix,help: Only process entries where the request starts with this prefix"`
+	RewritePath      []string      `arg:"--rewrite-path,help: Rewrite the request by a rule of the form 'regex=>replacement'"`
+	DropParam        []string      `arg:"--drop-param,help: Remove this query parameter (glob patterns like utm_* are allowed)"`
+	SetParam         []string      `arg:"--set-param,help: Add or replace a query parameter given as name=value"`
+	MapHost          []string      `arg:"--map-host,help: Replay requests of a host against another base url given as host=base-url"`
+	PreserveHost     bool          `arg:"--preserve-host,help: Send the host of the log entry as Host header"`
+	TraceContext     string        `arg:"--trace-context,help: Send w3c traceparent headers with a new trace per 'request' or one trace per user 'session'"`
+	TraceState       string        `arg:"--trace-state,help: Value of the tracestate header sent with the --trace-context"`
+	TraceB3          bool          `arg:"--trace-b3,help: Also send the trace context as b3 header"`
+	CandidateUrl     string        `arg:"--candidate-url,help: A second base url to call with every request for comparison"`
+	VerifyBodies     bool          `arg:"--verify-bodies,help: Compare the response bodies of the base url and the candidate url"`
+	IgnoreJSON       []string      `arg:"--ignore-json-path,help: Json path to ignore in the body comparison (like meta.requestId or items.*.updated)"`
+	MaskRegex        []string      `arg:"--mask-regex,help: Pattern to mask in the body comparison (like timestamps or ids)"`
+	Timeout          time.Duration `arg:"--timeout,help: Timeout for a replayed request"`
+	ConnectTimeout   time.Duration `arg:"--connect-timeout,help: Timeout for connection setup and tls handshake"`
+	Seed             int64         `arg:"--seed,help: Seed for sampling and generated ids to make replays reproducible (random if 0)"`
+	Manifest         string        `arg:"--manifest,help: Write the version seed options and input file hashes of the run as json into this file"`
+	ReorderWindow    time.Duration `arg:"--reorder-window,help: Buffer the merged entries for this log time to sort slightly unsorted logs"`
+	Checkpoint       string        `arg:"--checkpoint,help: Write the position in the log files periodically into this file"`
+	CheckpointEvery  time.Duration `arg:"--checkpoint-interval,help: Interval of writing the --checkpoint"`
+	Resume           bool          `arg:"--resume,help: Continue after the position in the --checkpoint file"`
+	Duration         time.Duration `arg:"--duration,help: Stop reading the log after this time (e.g. 30m)"`
+	GracePeriod      time.Duration `arg:"--grace-period,help: Time to wait for queued requests and index batches at the end or after an interrupt"`
+	FlushTimeout     time.Duration `arg:"--flush-timeout,help: Time for the outputs to send their last batches if the replay used up the grace period"`
+	Insecure         bool          `arg:"--insecure,help: Skip the verification of tls certificates"`
+	CAFile           string        `arg:"--ca-file,help: Pem file with additional ca certificates"`
+	ClientCert       string        `arg:"--client-cert,help: Pem file with the tls client certificate"`
+	ClientKey        string        `arg:"--client-key,help: Pem file with the key of the tls client certificate"`
+	HTTPVersion      string        `arg:"--http-version,help: Http version to use: auto/1.1/2 where 2 needs https and fails responses with http/1.1"`
+	Proxy            string        `arg:"--proxy,help: Url of an upstream proxy (default: from the environment)"`
+	MaxIdleConns     int           `arg:"--max-idle-conns,help: Maximum idle connections per simulated user"`
+	DisableKeepAlive bool          `arg:"--disable-keep-alive,help: Open a new connection for every request"`
+	Header           []string      `arg:"--header,help: Additional request header as 'Name: value'. The value may be a template like {{.Clientip}}"`
+	PassHeader       []string      `arg:"--pass-header,help: Send this header from the log with the request (User-Agent or Referer)"`
+}
+
+func defaultArgs() *Args {
+	return &Args{
+		ShowErrors:      false,
+		Limit:           math.MaxInt32,
+		RegexIgnore:     `healthcheck`,
+		RegexAssets:     `\.jpg|\.jpeg|\.png|\.ico|\.css|\.js|\.svg|\.gif|\.pdf|\.xml|\.woff|\.eot`,
+		RegexAjax:       `jsonp_callback|\.json`,
+		RegexSearch:     `\?q=|\&q=`,
+		BaseUrl:         "http://127.0.0.1",
+		Username:        "",
+		Password:        "",
+		EsBatchSize:     1000,
+		EsFlushInterval: 100 * time.Millisecond,
+		EsWorkers:       4,
+		LogstashBatch:   100,
+		LogstashFlush:   time.Second,
+		InfluxMeasure:   "replay",
+		StatsdPrefix:    "replaybench",
+		MetricsFlush:    time.Second,
+		EsIndex:         "logstash-{2006-01-02}",
+		EsMaxRetries:    5,
+		EsTimeout:       30 * time.Second,
+		SampleBy:        SampleBySession,
+		SamplePercent:   100,
+		Timeout:         10 * time.Second,
+		ConnectTimeout:  5 * time.Second,
+		GracePeriod:     100 * time.Second,
+		FlushTimeout:    10 * time.Second,
+		CheckpointEvery: 10 * time.Second,
+		HTTPVersion:     HTTPVersionAuto,
+		MaxIdleConns:    6,
+	}
+}
+
+// parseArgs merges the defaults, the config file and the command line flags, in that order of precedence.
+// Only the flags given on the command line override values from the config file.
+func parseArgs(command string, cliArgs []string) (*Args, *arg.Parser) {
+	program := "replaybench"
+	if command != "" {
+		program += " " + command
+	}
+
+	flagArgs := defaultArgs()
+	p, err := arg.NewParser(arg.Config{Program: program}, flagArgs)
+	if err != nil {
+		panic(err)
+	}
+	if err := p.Parse(cliArgs); err == arg.ErrHelp {
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	} else if err != nil {
+		p.Fail(err.Error())
+	}
+
+	if flagArgs.Config == "" {
+		return flagArgs, p
+	}
+
+	merged := defaultArgs()
+	if err := loadConfigFile(flagArgs.Config, merged); err != nil {
+		p.Fail(err.Error())
+	}
+	mergeGivenFlags(merged, flagArgs, cliArgs)
+	merged.Config = flagArgs.Config
+	return merged, p
+}
+
+func loadConfigFile(fileName string, args *Args) error {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	values := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("error parsing %v: %v", fileName, err)
+	}
+
+	fields := argsFields()
+	v := reflect.ValueOf(args).Elem()
+	for _, item := range values {
+		key := fmt.Sprintf("%v", item.Key)
+		field, exist := fields[key]
+		if !exist || key == "config" {
+			return fmt.Errorf("unknown option %q in %v", key, fileName)
+		}
+		fieldV := v.FieldByIndex(field.Index)
+
+		value := item.Value
+		if _, isList := value.([]interface{}); fieldV.Kind() == reflect.Slice && !isList && value != nil {
+			value = []interface{}{value}
+		}
+		raw, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		target := reflect.New(fieldV.Type())
+		if err := yaml.Unmarshal(raw, target.Interface()); err != nil {
+			return fmt.Errorf("invalid value for %q in %v: %v", key, fileName, err)
+		}
+		fieldV.Set(target.Elem())
+	}
+	return nil
+}
+
+// mergeGivenFlags copies the values of all flags, which were given on the command line.
+func mergeGivenFlags(merged, flagArgs *Args, cliArgs []string) {
+	given := make(map[string]bool)
+	for _, a := range cliArgs {
+		if a == "--" {
+			break
+		}
+		if strings.HasPrefix(a, "-") {
+			given[strings.SplitN(a, "=", 2)[0]] = true
+		}
+	}
+
+	mergedV := reflect.ValueOf(merged).Elem()
+	flagV := reflect.ValueOf(flagArgs).Elem()
+	for name, field := range argsFields() {
+		flag := flagV.FieldByIndex(field.Index)
+		isPositional := strings.HasPrefix(field.Tag.Get("arg"), "positional")
+		if (isPositional && flag.Len() > 0) || given["--"+name] || given[shortFlag(field)] {
+			mergedV.FieldByIndex(field.Index).Set(flag)
+		}
+	}
+}
+
+// printConfig writes the effective options in the format of the config file.
+func printConfig(w io.Writer, args *Args) error {
+	values := yaml.MapSlice{}
+	v := reflect.ValueOf(args).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := optionName(t.Field(i))
+		if name == "config" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if d, isDuration := value.(time.Duration); isDuration {
+			value = d.String()
+		}
+		values = append(values, yaml.MapItem{Key: name, Value: maskSecret(name, value, hideSecret)})
+	}
+
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// secretOptions are masked when printing the options, as well as the values of secret headers in headerOptions.
+var (
+	secretOptions = []string{"password", "bearer-token", "oauth2-client-secret", "es-password", "es-api-key", "influx-token"}
+	headerOptions = []string{"header", "otlp-header"}
+)
+
+// maskSecret replaces the value of a secret option and of the secret headers of header options by the result of mask.
+func maskSecret(name string, value interface{}, mask func(secret string) string) interface{} {
+	switch value := value.(type) {
+	case string:
+		if value != "" && contains(secretOptions, name) {
+			return mask(value)
+		}
+	case []string:
+		if contains(headerOptions, name) {
+			return maskHeaderValues(value, mask)
+		}
+	}
+	return value
+}
+
+// hideSecret is the mask for printing the options.
+func hideSecret(secret string) string {
+	return "***"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// maskHeaderValues masks the values of headers with secret names like 'X-Api-Key: secret',
+// other headers like 'X-Forwarded-For: {{.Clientip}}' are kept.
+func maskHeaderValues(headers []string, mask func(secret string) string) []string {
+	masked := make([]string, 0, len(headers))
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) == 2 && isSecretHeader(parts[0]) {
+			h = parts[0] + ": " + mask(strings.TrimSpace(parts[1]))
+		}
+		masked = append(masked, h)
+	}
+	return masked
+}
+
+// isSecretHeader reports whether the header usually contains credentials.
+func isSecretHeader(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.Contains(name, "authorization") || strings.Contains(name, "cookie") ||
+		strings.HasSuffix(name, "-key") || strings.Contains(name, "token") ||
+		strings.Contains(name, "secret") || strings.Contains(name, "password")
+}
+
+func argsFields() map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	t := reflect.TypeOf(Args{})
+	for i := 0; i < t.NumField(); i++ {
+		fields[optionName(t.Field(i))] = t.Field(i)
+	}
+	return fields
+}
+
+// optionName returns the long flag name of the field, which is also used as key in the config file.
+func optionName(field reflect.StructField) string {
+	for _, part := range strings.Split(field.Tag.Get("arg"), ",") {
+		if strings.HasPrefix(part, "--") {
+			return part[2:]
+		}
+	}
+	return kebabCase(field.Name)
+}
+
+func shortFlag(field reflect.StructField) string {
+	for _, part := range strings.Split(field.Tag.Get("arg"), ",") {
+		if len(part) == 2 && part[0] == '-' {
+			return part
+		}
+	}
+	return ""
+}
+
+func kebabCase(name string) string {
+	b := strings.Builder{}
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteRune('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_ParseArgs_ConfigFileAndFlags(t *testing.T) {
+	a := assert.New(t)
+
+	file, err := ioutil.TempFile("", "bench.yaml")
+	a.NoError(err)
+	defer os.Remove(file.Name())
+	file.WriteString("base-url: http://staging.local\nstatus: [200, 2xx]\nverb: GET\ntimeout: 30s\nverbose: true\nlog-files: [a.log]\n")
+	file.Close()
+
+	args, _ := parseArgs("", []string{"--config", file.Name(), "--timeout=5s", "--sample-percent", "10"})
+
+	a.Equal("http://staging.local", args.BaseUrl)
+	a.Equal([]string{"200", "2xx"}, args.Status)
+	a.Equal([]string{"GET"}, args.Verb)
+	a.Equal(5*time.Second, args.Timeout)
+	a.Equal(float64(10), args.SamplePercent)
+	a.True(args.Verbose)
+	a.Equal([]string{"a.log"}, args.LogFiles)
+	a.Equal(5*time.Second, args.ConnectTimeout)
+
+	args, _ = parseArgs("", []string{"--config", file.Name(), "b.log"})
+	a.Equal([]string{"b.log"}, args.LogFiles)
+	a.Equal(30*time.Second, args.Timeout)
+}
+
+func Test_PrintConfig_RoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	args := defaultArgs()
+	args.Header = []string{"X-Forwarded-For: {{.Clientip}}"}
+	args.Timeout = 42 * time.Second
+
+	buff := &bytes.Buffer{}
+	a.NoError(printConfig(buff, args))
+	a.Contains(buff.String(), "timeout: 42s\n")
+
+	file, err := ioutil.TempFile("", "bench.yaml")
+	a.NoError(err)
+	defer os.Remove(file.Name())
+	file.Write(buff.Bytes())
+	file.Close()
+
+	loaded := &Args{}
+	a.NoError(loadConfigFile(file.Name(), loaded))
+	a.Equal(args.Header, loaded.Header)
+	a.Equal(args.Timeout, loaded.Timeout)
+	a.Equal(args.Limit, loaded.Limit)
+
+	reprinted := &bytes.Buffer{}
+	a.NoError(printConfig(reprinted, loaded))
+	a.Equal(buff.String(), reprinted.String())
+}
+
+func Test_PrintConfig_MasksSecrets(t *testing.T) {
+	a := assert.New(t)
+
+	args := defaultArgs()
+	args.Password = "secret"
+	args.EsAPIKey = "secret"
+	args.Header = []string{"X-Api-Key: secret", "Authorization: Bearer secret", "X-Forwarded-For: {{.Clientip}}"}
+
+	buff := &bytes.Buffer{}
+	a.NoError(printConfig(buff, args))
+	a.NotContains(buff.String(), ": secret")
+	a.NotContains(buff.String(), "Bearer secret")
+	a.Contains(buff.String(), "password: '***'\n")
+	a.Contains(buff.String(), "- 'X-Api-Key: ***'\n")
+	a.Contains(buff.String(), "- 'Authorization: ***'\n")
+	a.Contains(buff.String(), "- 'X-Forwarded-For: {{.Clientip}}'\n")
+}
+
+func Test_LoadConfigFile_UnknownOption(t *testing.T) {
+	a := assert.New(t)
+
+	file, err := ioutil.TempFile("", "bench.yaml")
+	a.NoError(err)
+	defer os.Remove(file.Name())
+	file.WriteString("base-urls: http://staging.local\n")
+	file.Close()
+
+	a.Error(loadConfigFile(file.Name(), &Args{}))
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"sync"
 )
 
+func init() {
+	RegisterProcessor("count", "count the entries per content type, verb and request", func(args *Args) (Processor, error) {
+		return NewCountProcessor(), nil
+	})
+}
+
 type CountProcessor struct {
 	mutex  *sync.Mutex
 	counts map[string]int
@@ -18,7 +25,7 @@ func NewCountProcessor() *CountProcessor {
 	}
 }
 
-func (cp *CountProcessor) Process(l *LogEntry) error {
+func (cp *CountProcessor) Process(ctx context.Context, l *LogEntry) error {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 
@@ -2,110 +2,357 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 )
 
+func init() {
+	RegisterProcessor("es", "index the entries in elasticsearch at --es-url", func(args *Args) (Processor, error) {
+		if args.EsURL == "" {
+			return nil, errors.New("no --es-url given")
+		}
+		return NewElasticsearchIndexer(ElasticsearchOptions{
+			URL:              args.EsURL,
+			Username:         args.EsUsername,
+			Password:         args.EsPassword,
+			APIKey:           args.EsAPIKey,
+			Insecure:         args.EsInsecure,
+			CAFile:           args.EsCAFile,
+			ClientCert:       args.EsClientCert,
+			ClientKey:        args.EsClientKey,
+			BatchSize:        args.EsBatchSize,
+			FlushInterval:    args.EsFlushInterval,
+			Workers:          args.EsWorkers,
+			Index:            args.EsIndex,
+			DataStream:       args.EsDataStream,
+			DeleteAfter:      args.EsDeleteAfter,
+			SkipTemplate:     args.EsSkipTemplate,
+			MaxRetries:       args.EsMaxRetries,
+			DeadLetterFile:   args.EsDeadLetter,
+			AppendDeadLetter: resumePoint != nil,
+			Timeout:          args.EsTimeout,
+		})
+	})
+}
+
+type ElasticsearchOptions struct {
+	URL      string
+	Username string
+	Password string
+	// APIKey as 'id:api_key' or base64 encoded, as returned by the create api key api
+	APIKey     string
+	Insecure   bool
+	CAFile     string
+	ClientCert string
+	ClientKey  string
+	// BatchSize is the maximum number of documents per bulk request
+	BatchSize int
+	// FlushInterval is the maximum time to collect the documents of a bulk request
+	FlushInterval time.Duration
+	Workers       int
+	// Index name pattern with a time layout in curly braces, e.g. 'logstash-{2006-01-02}'
+	Index string
+	// DataStream to write into instead of the Index
+	DataStream string
+	// DeleteAfter is the age after which an ilm policy deletes the indices, e.g. '30d'
+	DeleteAfter  string
+	SkipTemplate bool
+	// MaxRetries of a document after a 429 or 5xx response
+	MaxRetries int
+	// DeadLetterFile gets the documents which could not be indexed as json lines
+	DeadLetterFile string
+	// AppendDeadLetter continues an existing dead-letter file, e.g. on --resume
+	AppendDeadLetter bool
+	// Timeout of every request, so that an unresponsive cluster doesn't block the pipeline
+	Timeout time.Duration
+}
+
 type ElasticsearchIndexer struct {
-	baseurl       string
-	fanout        chan *LogEntry
-	shouldFinishC chan bool
-	workerDone    []chan bool
+	*BatchSink
+	options      ElasticsearchOptions
+	baseurl      string
+	client       *http.Client
+	version      esVersion
+	retryBackoff time.Duration
+	deadLetter   *JSONLinesProcessor
+	deadLetterF  *os.File
+}
+
+// bulkDocument is one document of a bulk request.
+type bulkDocument struct {
+	index  string
+	source json.RawMessage
+}
+
+// bulkResponse is the part of the _bulk response needed to find the failed documents.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
 }
 
-func NewElasticsearchIndexer(baseurl string) *ElasticsearchIndexer {
+func NewElasticsearchIndexer(options ElasticsearchOptions) (*ElasticsearchIndexer, error) {
+	baseurl := options.URL
 	for strings.HasSuffix(baseurl, "/") {
 		baseurl = baseurl[:len(baseurl)-1]
 	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 1000
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 100 * time.Millisecond
+	}
+	if options.Workers <= 0 {
+		options.Workers = 4
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	if options.APIKey != "" && options.Username != "" {
+		return nil, errors.New("elasticsearch username and api key can not be used together")
+	}
+	tlsConfig, err := loadTLSConfig(options.Insecure, options.CAFile, options.ClientCert, options.ClientKey)
+	if err != nil {
+		return nil, err
+	}
 	ei := &ElasticsearchIndexer{
-		baseurl:       baseurl,
-		fanout:        make(chan *LogEntry, 100),
-		shouldFinishC: make(chan bool),
-		workerDone:    make([]chan bool, 4),
+		options: options,
+		baseurl: baseurl,
+		client: &http.Client{
+			Timeout: options.Timeout,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   options.Timeout,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: options.Timeout,
+			},
+		},
+		retryBackoff: 500 * time.Millisecond,
 	}
-	for i, _ := range ei.workerDone {
-		ei.workerDone[i] = make(chan bool)
-		go ei.startWorker(ei.shouldFinishC, ei.workerDone[i])
+
+	// fail early, instead of blocking the replay later
+	if ei.version, err = ei.detectVersion(); err != nil {
+		return nil, fmt.Errorf("can not connect to elasticsearch at %v: %v", baseurl, err)
 	}
-	return ei
+	if !options.SkipTemplate {
+		if err := ei.setup(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.DeadLetterFile != "" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if options.AppendDeadLetter {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(options.DeadLetterFile, flags, 0644)
+		if err != nil {
+			return nil, err
+		}
+		ei.deadLetterF = f
+		ei.deadLetter = NewJSONLinesProcessor(f)
+	}
+	ei.BatchSink = NewBatchSink("elasticsearch", BatchOptions{
+		BatchSize:     options.BatchSize,
+		FlushInterval: options.FlushInterval,
+		Workers:       options.Workers,
+		Unreplayed:    true,
+	}, ei.send)
+	return ei, nil
 }
 
-func (ei *ElasticsearchIndexer) Process(l *LogEntry) error {
-	l.wg.Wait()
-	ei.fanout <- l
-	return nil
+func (ei *ElasticsearchIndexer) send(ctx context.Context, batch []*LogEntry) (int, error) {
+	docs := make([]*bulkDocument, 0, len(batch))
+	var marshalErr error
+	for _, l := range batch {
+		js, err := json.Marshal(l)
+		if err != nil {
+			marshalErr = err
+			continue
+		}
+		docs = append(docs, &bulkDocument{
+			index:  indexName(ei.indexPattern(), l.Timestamp),
+			source: js,
+		})
+	}
+	return ei.index(ctx, docs), marshalErr
 }
 
-func (ei *ElasticsearchIndexer) startWorker(shouldFinishC, done chan bool) {
-	shouldFinish := false
-	for {
-		bulkTimeout := time.After(100 * time.Millisecond)
-		documentCount := 0
-		buff := bytes.NewBufferString("")
-	bulkaggregate:
-		for documentCount < 1000 {
-			select {
-			case l := <-ei.fanout:
-				if l.ContentType == "ignore" {
-					l.wg.Done()
-					continue
-				}
-
-				documentCount++
-				if js, err := json.Marshal(l); err != nil {
-					fmt.Fprintf(os.Stderr, err.Error())
-					continue
-				} else {
-					buff.WriteString(fmt.Sprintf(`{"index":{"_index": "logstash-%v", "_type": "log"}}`, l.Timestamp.Format("2006-01-02")))
-					buff.WriteString("\n")
-					buff.WriteString(string(js))
-					buff.WriteString("\n")
-				}
-			case <-bulkTimeout:
-				break bulkaggregate
-			case <-shouldFinishC:
-				shouldFinish = true
-				break bulkaggregate
-			}
+// index sends the documents and retries the ones rejected with 429 or 5xx
+// with exponential backoff. The others are written to the dead-letter file.
+// It returns the number of indexed documents.
+func (ei *ElasticsearchIndexer) index(ctx context.Context, docs []*bulkDocument) int {
+	indexed := 0
+	backoff := ei.retryBackoff
+	for attempt := 0; ; attempt++ {
+		ok, retry, err := ei.bulk(ctx, docs)
+		indexed += ok
+		if len(retry) == 0 {
+			return indexed
 		}
-		if documentCount > 0 {
-			//start := time.Now()
-			resp, err := http.Post(ei.baseurl+"/_bulk", "application/json", buff)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%v\n", err.Error())
-				continue
-			}
-			ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-			if resp.StatusCode != 200 {
-				fmt.Fprintf(os.Stderr, "http error %v\n", resp.StatusCode)
-				continue
-			}
-			//fmt.Fprintf(os.Stderr, "bulk with %v documents(took %v)\n", documentCount, time.Since(start))
+		if attempt >= ei.options.MaxRetries {
+			ei.writeDeadLetters(retry, fmt.Sprintf("giving up after %v retries: %v", attempt, err))
+			return indexed
 		}
-		if shouldFinish {
-			done <- true
-			return
+		select {
+		case <-ctx.Done():
+			ei.writeDeadLetters(retry, ctx.Err().Error())
+			return indexed
+		case <-time.After(backoff):
 		}
+		docs = retry
+		backoff *= 2
 	}
 }
 
-func (ei *ElasticsearchIndexer) Finish() chan bool {
-	done := make(chan bool)
-	go func() {
-		for len(ei.fanout) > 0 {
-			time.Sleep(10 * time.Millisecond)
+// bulk sends the documents in one bulk request.
+// It returns the number of indexed documents and the ones which should be retried together with their error.
+func (ei *ElasticsearchIndexer) bulk(ctx context.Context, docs []*bulkDocument) (int, []*bulkDocument, error) {
+	action := "index"
+	if ei.options.DataStream != "" {
+		// data streams are append only
+		action = "create"
+	}
+	body := &bytes.Buffer{}
+	for _, doc := range docs {
+		if ei.version.typeless() {
+			fmt.Fprintf(body, `{"%v":{"_index": "%v"}}`, action, doc.index)
+		} else {
+			fmt.Fprintf(body, `{"%v":{"_index": "%v", "_type": "log"}}`, action, doc.index)
 		}
-		close(ei.shouldFinishC) // close does a broadcast
-		for _, c := range ei.workerDone {
-			<-c
+		body.WriteString("\n")
+		body.Write(doc.source)
+		body.WriteString("\n")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", ei.baseurl+"/_bulk", body)
+	if err != nil {
+		ei.writeDeadLetters(docs, err.Error())
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := ei.do(request)
+	if err != nil {
+		return 0, docs, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, docs, err
+	}
+
+	if resp.StatusCode == 429 || resp.StatusCode >= 500 {
+		return 0, docs, fmt.Errorf("http error %v", resp.StatusCode)
+	}
+	if resp.StatusCode != 200 {
+		err := fmt.Errorf("http error %v: %s", resp.StatusCode, excerpt(respBody, 0))
+		ei.writeDeadLetters(docs, err.Error())
+		return 0, nil, err
+	}
+
+	result := &bulkResponse{}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		err := fmt.Errorf("can not parse bulk response: %v: %s", err, excerpt(respBody, 0))
+		ei.writeDeadLetters(docs, err.Error())
+		return 0, nil, err
+	}
+	if len(result.Items) != len(docs) {
+		err := fmt.Errorf("bulk response has %v items for %v documents", len(result.Items), len(docs))
+		ei.writeDeadLetters(docs, err.Error())
+		return 0, nil, err
+	}
+	if !result.Errors {
+		return len(docs), nil, nil
+	}
+
+	indexed := 0
+	retry := []*bulkDocument{}
+	var retryErr error
+	for i, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status < 300:
+				indexed++
+			case status.Status == 429 || status.Status >= 500:
+				retry = append(retry, docs[i])
+				retryErr = fmt.Errorf("status %v: %s", status.Status, status.Error)
+			default:
+				ei.writeDeadLetter(docs[i], fmt.Sprintf("status %v: %s", status.Status, status.Error))
+			}
+		}
+	}
+	return indexed, retry, retryErr
+}
+
+// do sends the request with the credentials of the options.
+func (ei *ElasticsearchIndexer) do(request *http.Request) (*http.Response, error) {
+	if ei.options.APIKey != "" {
+		apiKey := ei.options.APIKey
+		if strings.Contains(apiKey, ":") {
+			apiKey = base64.StdEncoding.EncodeToString([]byte(apiKey))
 		}
-		done <- true
-	}()
-	return done
+		request.Header.Set("Authorization", "ApiKey "+apiKey)
+	} else if ei.options.Username != "" {
+		request.SetBasicAuth(ei.options.Username, ei.options.Password)
+	}
+	return ei.client.Do(request)
+}
+
+func (ei *ElasticsearchIndexer) writeDeadLetters(docs []*bulkDocument, reason string) {
+	for _, doc := range docs {
+		ei.writeDeadLetter(doc, reason)
+	}
+}
+
+func (ei *ElasticsearchIndexer) writeDeadLetter(doc *bulkDocument, reason string) {
+	if ei.deadLetter == nil {
+		fmt.Fprintf(os.Stderr, "error indexing document: %v\n", reason)
+		return
+	}
+	err := ei.deadLetter.write(struct {
+		Index    string          `json:"index"`
+		Error    string          `json:"error"`
+		Document json.RawMessage `json:"document"`
+	}{doc.index, reason, doc.source})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing dead letter: %v\n", err)
+	}
+}
+
+// Finish sends the queued entries. If the context is done before,
+// the running bulk requests are aborted.
+func (ei *ElasticsearchIndexer) Finish(ctx context.Context) error {
+	err := ei.BatchSink.Finish(ctx)
+	if ei.deadLetter != nil {
+		ei.deadLetter.Finish(ctx)
+		ei.deadLetterF.Close()
+	}
+	return err
+}
+
+func (ei *ElasticsearchIndexer) PrintResults(w io.Writer) {
+	indexed, failed := ei.counts()
+	fmt.Fprintf(w, "indexed documents: %v\n", indexed)
+	if failed > 0 {
+		if ei.deadLetter != nil {
+			fmt.Fprintf(w, "failed documents: %v (written to %v)\n", failed, ei.options.DeadLetterFile)
+		} else {
+			fmt.Fprintf(w, "failed documents: %v\n", failed)
+		}
+	}
 }
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ElasticsearchIndexer_RetryAndDeadLetter(t *testing.T) {
+	a := assert.New(t)
+
+	responses := []struct {
+		status int
+		body   string
+	}{
+		{429, ``},
+		{200, `{"errors":true,"items":[
+			{"index":{"status":201}},
+			{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},
+			{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}]}`},
+		{200, `{"errors":false,"items":[{"index":{"status":201}}]}`},
+	}
+	requests := []string{}
+	server := newElasticsearchStub("8.11.0", "", nil, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		response := responses[len(requests)-1]
+		w.WriteHeader(response.status)
+		w.Write([]byte(response.body))
+	})
+	defer server.Close()
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	ei, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: server.URL, MaxRetries: 3, DeadLetterFile: deadLetterFile, Workers: 1})
+	a.NoError(err)
+	ei.retryBackoff = time.Millisecond
+
+	for _, request := range []string{"/a", "/b", "/c"} {
+		a.NoError(ei.Process(context.Background(), &LogEntry{Request: request}))
+	}
+	a.NoError(ei.Finish(context.Background()))
+
+	a.Equal(3, len(requests))
+	a.Equal(3, strings.Count(requests[1], `"Request"`))
+	a.Equal(1, strings.Count(requests[2], `"Request"`))
+	a.Contains(requests[2], `/c`)
+	deadLetters, err := ioutil.ReadFile(deadLetterFile)
+	a.NoError(err)
+	a.Equal(1, bytes.Count(deadLetters, []byte("\n")))
+	a.Contains(string(deadLetters), `"document":{"Clientip":"","Host":"","Verb":"","Request":"/b"`)
+	a.Contains(string(deadLetters), `mapper_parsing_exception`)
+
+	out := &bytes.Buffer{}
+	ei.PrintResults(out)
+	a.Equal("indexed documents: 2\nfailed documents: 1 (written to "+deadLetterFile+")\n", out.String())
+}
+
+func Test_ElasticsearchIndexer_GiveUpAfterRetries(t *testing.T) {
+	a := assert.New(t)
+	calls := 0
+	server := newElasticsearchStub("8.11.0", "", nil, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(503)
+	})
+	defer server.Close()
+
+	ei, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: server.URL, MaxRetries: 2})
+	a.NoError(err)
+	ei.retryBackoff = time.Millisecond
+
+	indexed := ei.index(context.Background(), []*bulkDocument{{index: "logstash-2016-05-29", source: []byte(`{}`)}})
+	a.NoError(ei.Finish(context.Background()))
+
+	a.Equal(3, calls)
+	a.Equal(0, indexed)
+}
+
+func Test_ElasticsearchIndexer_UnexpectedBulkResponse(t *testing.T) {
+	a := assert.New(t)
+	for _, body := range []string{`<html>proxy error</html>`, `{"errors":false,"items":[{"index":{"status":201}}]}`} {
+		server := newElasticsearchStub("8.11.0", "", nil, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		})
+
+		ei, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: server.URL})
+		a.NoError(err)
+
+		indexed := ei.index(context.Background(), []*bulkDocument{
+			{index: "logstash-2016-05-29", source: []byte(`{"Request":"/a"}`)},
+			{index: "logstash-2016-05-29", source: []byte(`{"Request":"/b"}`)},
+		})
+		a.NoError(ei.Finish(context.Background()))
+		server.Close()
+
+		a.Equal(0, indexed, body)
+	}
+}
+
+func Test_ElasticsearchIndexer_Versions(t *testing.T) {
+	tests := []struct {
+		number       string
+		distribution string
+		options      ElasticsearchOptions
+		setup        []string
+		action       string
+	}{
+		{"8.11.0", "", ElasticsearchOptions{Index: "logstash-{2006-01-02}"},
+			[]string{"PUT /_index_template/replaybench"},
+			`{"index":{"_index": "logstash-2016-05-29"}}`},
+		{"2.11.1", "opensearch", ElasticsearchOptions{Index: "replay-{2006.01}"},
+			[]string{"PUT /_index_template/replaybench"},
+			`{"index":{"_index": "replay-2016.05"}}`},
+		{"6.8.23", "", ElasticsearchOptions{Index: "logstash-{2006-01-02}"},
+			[]string{"PUT /_template/replaybench"},
+			`{"index":{"_index": "logstash-2016-05-29", "_type": "log"}}`},
+		{"7.17.0", "", ElasticsearchOptions{DataStream: "logs-replay", DeleteAfter: "30d"},
+			[]string{"PUT /_ilm/policy/replaybench", "PUT /_index_template/replaybench"},
+			`{"create":{"_index": "logs-replay"}}`},
+	}
+	for _, test := range tests {
+		a := assert.New(t)
+		setup := []string{}
+		bulk := ""
+		server := newElasticsearchStub(test.number, test.distribution, &setup, func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			bulk = string(body)
+		})
+
+		test.options.URL = server.URL
+		ei, err := NewElasticsearchIndexer(test.options)
+		a.NoError(err)
+		a.Equal(test.setup, setup, test.number)
+
+		l := &LogEntry{Request: "/a", Timestamp: time.Date(2016, 5, 29, 13, 0, 0, 0, time.UTC)}
+		a.NoError(ei.Process(context.Background(), l))
+		a.NoError(ei.Finish(context.Background()))
+		a.Equal(test.action, strings.Split(bulk, "\n")[0], test.number)
+		server.Close()
+	}
+}
+
+func Test_ElasticsearchIndexer_SetupErrors(t *testing.T) {
+	a := assert.New(t)
+	server := newElasticsearchStub("6.8.23", "", nil, nil)
+	defer server.Close()
+
+	_, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: server.URL, DataStream: "logs-replay"})
+	a.Error(err)
+
+	server2 := newElasticsearchStub("2.11.1", "opensearch", nil, nil)
+	defer server2.Close()
+	_, err = NewElasticsearchIndexer(ElasticsearchOptions{URL: server2.URL, Index: "x", DeleteAfter: "30d"})
+	a.Error(err)
+}
+
+// newElasticsearchStub answers the version requests and records the setup requests.
+func newElasticsearchStub(number, distribution string, setup *[]string, bulk http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprintf(w, `{"version":{"number":%q,"distribution":%q}}`, number, distribution)
+		case r.URL.Path == "/_bulk":
+			bulk(w, r)
+		default:
+			if setup != nil {
+				*setup = append(*setup, r.Method+" "+r.URL.Path)
+			}
+		}
+	}))
+}
+
+func Test_ElasticsearchIndexer_Auth(t *testing.T) {
+	tests := []struct {
+		options       ElasticsearchOptions
+		authorization string
+	}{
+		{ElasticsearchOptions{Username: "elastic", Password: "secret"}, "Basic ZWxhc3RpYzpzZWNyZXQ="},
+		{ElasticsearchOptions{APIKey: "id:key"}, "ApiKey aWQ6a2V5"},
+		{ElasticsearchOptions{APIKey: "aWQ6a2V5"}, "ApiKey aWQ6a2V5"},
+	}
+	for _, test := range tests {
+		a := assert.New(t)
+		authorization := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = append(authorization, r.Header.Get("Authorization"))
+			fmt.Fprintf(w, `{"version":{"number":"8.11.0"}}`)
+		}))
+
+		test.options.URL = server.URL
+		test.options.SkipTemplate = true
+		ei, err := NewElasticsearchIndexer(test.options)
+		a.NoError(err)
+		a.NoError(ei.Finish(context.Background()))
+		a.Equal([]string{test.authorization}, authorization)
+		server.Close()
+	}
+}
+
+func Test_ElasticsearchIndexer_CAFile(t *testing.T) {
+	a := assert.New(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"version":{"number":"6.8.23"}}`)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	a.NoError(ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	ei, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: server.URL, CAFile: caFile, SkipTemplate: true})
+	a.NoError(err)
+	a.Equal(6, ei.version.major)
+	a.NoError(ei.Finish(context.Background()))
+}
+
+func Test_ElasticsearchIndexer_NotReachable(t *testing.T) {
+	_, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: "http://127.0.0.1:1"})
+	assert.Error(t, err)
+}
+
+func Test_ElasticsearchIndexer_Timeout(t *testing.T) {
+	a := assert.New(t)
+	release := make(chan struct{})
+	stalled := func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(stalled))
+	start := time.Now()
+	_, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: server.URL, Timeout: 50 * time.Millisecond})
+	a.Error(err)
+	a.True(time.Since(start) < 5*time.Second)
+
+	stub := newElasticsearchStub("8.11.0", "", nil, stalled)
+	ei, err := NewElasticsearchIndexer(ElasticsearchOptions{URL: stub.URL, Timeout: 50 * time.Millisecond, MaxRetries: 1})
+	a.NoError(err)
+	ei.retryBackoff = time.Millisecond
+	indexed := ei.index(context.Background(), []*bulkDocument{{index: "logstash-2016-05-29", source: []byte(`{}`)}})
+	a.Equal(0, indexed)
+	a.NoError(ei.Finish(context.Background()))
+
+	close(release)
+	server.Close()
+	stub.Close()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const esTemplateName = "replaybench"
+
+var indexPatternRegexp = regexp.MustCompile(`\{[^}]*\}`)
+
+// esVersion is the version reported by the root endpoint of elasticsearch or opensearch.
+type esVersion struct {
+	distribution string
+	major        int
+	minor        int
+}
+
+func (v esVersion) isOpenSearch() bool {
+	return v.distribution == "opensearch"
+}
+
+// typeless is true for versions, which reject the _type in bulk requests.
+func (v esVersion) typeless() bool {
+	return v.isOpenSearch() || v.major >= 7
+}
+
+// composableTemplates is true for versions supporting _index_template.
+func (v esVersion) composableTemplates() bool {
+	return v.isOpenSearch() || v.major > 7 || (v.major == 7 && v.minor >= 8)
+}
+
+func (v esVersion) String() string {
+	name := "elasticsearch"
+	if v.isOpenSearch() {
+		name = "opensearch"
+	}
+	return fmt.Sprintf("%v %v.%v", name, v.major, v.minor)
+}
+
+// indexName replaces the time layouts in curly braces of the pattern by the formatted time,
+// e.g. 'logstash-{2006-01-02}' becomes 'logstash-2016-05-29'.
+func indexName(pattern string, t time.Time) string {
+	return indexPatternRegexp.ReplaceAllStringFunc(pattern, func(layout string) string {
+		return t.Format(layout[1 : len(layout)-1])
+	})
+}
+
+// indexWildcard is the pattern of all indices matching the index name pattern.
+func indexWildcard(pattern string) string {
+	return indexPatternRegexp.ReplaceAllString(pattern, "*")
+}
+
+// detectVersion asks the root endpoint for the version.
+func (ei *ElasticsearchIndexer) detectVersion() (esVersion, error) {
+	version := esVersion{}
+	request, err := http.NewRequest("GET", ei.baseurl+"/", nil)
+	if err != nil {
+		return version, err
+	}
+	resp, err := ei.do(request)
+	if err != nil {
+		return version, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return version, err
+	}
+	if resp.StatusCode != 200 {
+		return version, fmt.Errorf("http error %v: %s", resp.StatusCode, excerpt(body, 0))
+	}
+
+	info := struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}{}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return version, fmt.Errorf("error parsing version: %v", err)
+	}
+	parts := strings.SplitN(info.Version.Number, ".", 3)
+	if len(parts) < 2 {
+		return version, fmt.Errorf("error parsing version %q", info.Version.Number)
+	}
+	version.distribution = info.Version.Distribution
+	version.major, _ = strconv.Atoi(parts[0])
+	version.minor, _ = strconv.Atoi(parts[1])
+	return version, nil
+}
+
+// setup installs the ilm policy and the index template.
+func (ei *ElasticsearchIndexer) setup() error {
+	if ei.options.DataStream != "" && !ei.version.composableTemplates() {
+		return fmt.Errorf("data streams are not supported by %v", ei.version)
+	}
+
+	settings := map[string]interface{}{}
+	if ei.options.DeleteAfter != "" {
+		if ei.version.isOpenSearch() || ei.version.major < 7 {
+			return fmt.Errorf("ilm policies are not supported by %v", ei.version)
+		}
+		policy := map[string]interface{}{
+			"policy": map[string]interface{}{
+				"phases": map[string]interface{}{
+					"hot": map[string]interface{}{
+						"actions": map[string]interface{}{},
+					},
+					"delete": map[string]interface{}{
+						"min_age": ei.options.DeleteAfter,
+						"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+					},
+				},
+			},
+		}
+		if err := ei.put("/_ilm/policy/"+esTemplateName, policy); err != nil {
+			return fmt.Errorf("error installing ilm policy: %v", err)
+		}
+		settings["index.lifecycle.name"] = esTemplateName
+	}
+
+	mappings := esMappings()
+	if !ei.version.typeless() {
+		mappings = map[string]interface{}{"log": mappings}
+	}
+
+	if ei.version.composableTemplates() {
+		template := map[string]interface{}{
+			"index_patterns": []string{indexWildcard(ei.indexPattern())},
+			"priority":       200,
+			"template": map[string]interface{}{
+				"settings": settings,
+				"mappings": mappings,
+			},
+		}
+		if ei.options.DataStream != "" {
+			template["data_stream"] = map[string]interface{}{}
+		}
+		return ei.put("/_index_template/"+esTemplateName, template)
+	}
+
+	template := map[string]interface{}{
+		"index_patterns": []string{indexWildcard(ei.indexPattern())},
+		"order":          200,
+		"settings":       settings,
+		"mappings":       mappings,
+	}
+	return ei.put("/_template/"+esTemplateName, template)
+}
+
+func (ei *ElasticsearchIndexer) indexPattern() string {
+	if ei.options.DataStream != "" {
+		return ei.options.DataStream
+	}
+	return ei.options.Index
+}
+
+func (ei *ElasticsearchIndexer) put(path string, document interface{}) error {
+	js, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", ei.baseurl+path, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := ei.do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT %v: http error %v: %s", path, resp.StatusCode, excerpt(body, 0))
+	}
+	return nil
+}
+
+// esMappings are the mappings of the LogEntry fields.
+func esMappings() map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword", "ignore_above": 1024}
+	text := map[string]interface{}{"type": "text"}
+	typed := func(t string) map[string]interface{} {
+		return map[string]interface{}{"type": t}
+	}
+
+	result := map[string]interface{}{
+		"Target":          keyword,
+		"DurationMs":      typed("integer"),
+		"DNSMs":           typed("float"),
+		"ConnectMs":       typed("float"),
+		"TLSMs":           typed("float"),
+		"TTFBMs":          typed("float"),
+		"ConnReused":      typed("boolean"),
+		"ResponseBytes":   typed("long"),
+		"ContentEncoding": keyword,
+		"Status":          typed("integer"),
+		"Error":           typed("boolean"),
+		"ErrorCategory":   keyword,
+		"ErrorMessage":    text,
+	}
+	candidate := map[string]interface{}{
+		"StatusMismatch": typed("boolean"),
+		"DurationDiffMs": typed("integer"),
+		"BodyMismatch":   typed("boolean"),
+		"BodyDiff":       text,
+	}
+	replay := map[string]interface{}{
+		"Offset": typed("long"),
+		"Candidate": map[string]interface{}{
+			"properties": candidate,
+		},
+	}
+	for name, mapping := range result {
+		candidate[name] = mapping
+		replay[name] = mapping
+	}
+
+	return map[string]interface{}{
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{
+				"strings_as_keywords": map[string]interface{}{
+					"match_mapping_type": "string",
+					"mapping":            keyword,
+				},
+			},
+		},
+		"properties": map[string]interface{}{
+			"@timestamp":    typed("date"),
+			"Clientip":      keyword,
+			"Host":          keyword,
+			"Verb":          keyword,
+			"Request":       keyword,
+			"Httpversion":   keyword,
+			"Response":      typed("integer"),
+			"Referer":       keyword,
+			"UserAgent":     keyword,
+			"ContentType":   keyword,
+			"CorrelationId": keyword,
+			"TraceId":       keyword,
+			"SpanId":        keyword,
+			"ParentSpanId":  keyword,
+			"Replay": map[string]interface{}{
+				"properties": replay,
+			},
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+func init() {
+	RegisterProcessor("errors", "count the replay errors per category", func(args *Args) (Processor, error) {
+		return NewErrorCountProcessor(), nil
+	})
+}
+
+// ErrorCountProcessor counts the replay errors per category.
+type ErrorCountProcessor struct {
+	mutex  *sync.Mutex
+	total  int
+	counts map[string]int
+}
+
+func NewErrorCountProcessor() *ErrorCountProcessor {
+	return &ErrorCountProcessor{
+		mutex:  &sync.Mutex{},
+		counts: make(map[string]int),
+	}
+}
+
+func (ep *ErrorCountProcessor) Process(ctx context.Context, l *LogEntry) error {
+	l.wg.Wait()
+	replayed := l.Replay.Status != 0 || l.Replay.Error
+	if l.ContentType == "ignore" || !replayed {
+		return nil
+	}
+
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+
+	ep.total++
+	if l.Replay.Error {
+		ep.counts[string(l.Replay.ErrorCategory)]++
+	}
+	if l.Replay.Candidate != nil && l.Replay.Candidate.Error {
+		ep.counts["candidate "+string(l.Replay.Candidate.ErrorCategory)]++
+	}
+	return nil
+}
+
+func (ep *ErrorCountProcessor) PrintResults(w io.Writer) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+
+	categories := make([]string, 0, len(ep.counts))
+	for category := range ep.counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	fmt.Fprintf(w, "replayed requests: %v\n", ep.total)
+	for _, category := range categories {
+		fmt.Fprintf(w, "%8v %v\n", ep.counts[category], category)
+	}
+}
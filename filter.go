@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterProcessor("filter", "ignore the entries not matching the filter options like --from/--to or --status", func(args *Args) (Processor, error) {
+		status := args.Status
+		if len(status) == 0 {
+			status = []string{"200"}
+		}
+		return NewEntryFilter(args.From, args.To, args.Verb, status, args.ContentType, args.ClientCIDR, args.Host, args.PathPrefix)
+	})
+}
+
+var filterTimePatterns = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var statusPatternRegexp = regexp.MustCompile(`^[1-5x][0-9x][0-9x]$`)
+
+// EntryFilter selects the log entries to process.
+// Empty criteria match every entry.
+type EntryFilter struct {
+	from         time.Time
+	to           time.Time
+	verbs        map[string]bool
+	statuses     []string
+	contentTypes map[string]bool
+	networks     []*net.IPNet
+	hosts        map[string]bool
+	pathPrefixes []string
+	warnedHost   bool
+}
+
+// NewEntryFilter creates a filter from the command line values.
+// Times may be given as RFC3339 or as local time like '2016-05-29 18:00',
+// status codes may contain x as wildcard, e.g. '2xx'.
+func NewEntryFilter(from, to string, verbs, statuses, contentTypes, cidrs, hosts, pathPrefixes []string) (*EntryFilter, error) {
+	f := &EntryFilter{
+		verbs:        toSet(splitValues(verbs), strings.ToUpper),
+		contentTypes: toSet(splitValues(contentTypes), strings.ToLower),
+		hosts:        toSet(splitValues(hosts), strings.ToLower),
+		pathPrefixes: splitValues(pathPrefixes),
+	}
+
+	var err error
+	if f.from, err = parseFilterTime(from); err != nil {
+		return nil, fmt.Errorf("invalid --from: %v", err)
+	}
+	if f.to, err = parseFilterTime(to); err != nil {
+		return nil, fmt.Errorf("invalid --to: %v", err)
+	}
+	if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+		return nil, fmt.Errorf("--from (%v) has to be before --to (%v)", from, to)
+	}
+
+	for _, status := range splitValues(statuses) {
+		status = strings.ToLower(status)
+		if !statusPatternRegexp.MatchString(status) {
+			return nil, fmt.Errorf("invalid status pattern %q", status)
+		}
+		f.statuses = append(f.statuses, status)
+	}
+
+	for _, cidr := range splitValues(cidrs) {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		f.networks = append(f.networks, network)
+	}
+
+	return f, nil
+}
+
+func (f *EntryFilter) Process(ctx context.Context, l *LogEntry) error {
+	if l.ContentType != "ignore" && !f.Match(l) {
+		l.ContentType = "ignore"
+	}
+	return nil
+}
+
+func (f *EntryFilter) Match(l *LogEntry) bool {
+	if !f.from.IsZero() && l.Timestamp.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && !l.Timestamp.Before(f.to) {
+		return false
+	}
+	if len(f.verbs) > 0 && !f.verbs[l.Verb] {
+		return false
+	}
+	if len(f.statuses) > 0 && !f.matchStatus(l.Response) {
+		return false
+	}
+	if len(f.contentTypes) > 0 && !f.contentTypes[l.ContentType] {
+		return false
+	}
+	if len(f.networks) > 0 && !f.matchClientip(l.Clientip) {
+		return false
+	}
+	if len(f.hosts) > 0 && !f.hosts[strings.ToLower(l.Host)] {
+		if l.Host == "" && !f.warnedHost {
+			f.warnedHost = true
+			fmt.Fprintf(os.Stderr, "warning: entries without host never match --host, the log needs absolute request urls or a virtual host field\n")
+		}
+		return false
+	}
+	if len(f.pathPrefixes) > 0 && !f.matchPathPrefix(l.Request) {
+		return false
+	}
+	return true
+}
+
+func (f *EntryFilter) matchStatus(response int) bool {
+	code := fmt.Sprintf("%03d", response)
+	for _, status := range f.statuses {
+		matches := true
+		for i := range status {
+			if status[i] != 'x' && status[i] != code[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *EntryFilter) matchClientip(clientip string) bool {
+	ip := net.ParseIP(clientip)
+	if ip == nil {
+		return false
+	}
+	for _, network := range f.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *EntryFilter) matchPathPrefix(request string) bool {
+	for _, prefix := range f.pathPrefixes {
+		if strings.HasPrefix(request, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFilterTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, pattern := range filterTimePatterns {
+		if t, err := time.ParseInLocation(pattern, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can not parse time %q, expected one of %q", value, filterTimePatterns)
+}
+
+// splitValues allows to pass lists as repeated values or comma separated.
+func splitValues(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+func toSet(values []string, normalize func(string) string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[normalize(v)] = true
+	}
+	return set
+}
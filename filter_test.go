@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_EntryFilter_Match(t *testing.T) {
+	a := assert.New(t)
+
+	f, err := NewEntryFilter("2016-05-29T18:00:00+02:00", "2016-05-29T19:00:00+02:00",
+		[]string{"get"}, []string{"2xx,304"}, []string{"page"}, []string{"10.0.0.0/8", "192.168.1.1"},
+		[]string{"www.example.org"}, []string{"/checkout"})
+	a.NoError(err)
+
+	timestamp, _ := time.Parse(time.RFC3339, "2016-05-29T18:30:00+02:00")
+	newEntry := func() *LogEntry {
+		return &LogEntry{
+			Timestamp:   timestamp,
+			Verb:        "GET",
+			Response:    200,
+			ContentType: "page",
+			Clientip:    "10.1.2.3",
+			Host:        "www.example.org",
+			Request:     "/checkout/cart",
+		}
+	}
+
+	a.True(f.Match(newEntry()))
+
+	for _, modify := range []func(l *LogEntry){
+		func(l *LogEntry) { l.Timestamp = timestamp.Add(-time.Hour) },
+		func(l *LogEntry) { l.Timestamp = timestamp.Add(30 * time.Minute) },
+		func(l *LogEntry) { l.Verb = "POST" },
+		func(l *LogEntry) { l.Response = 302 },
+		func(l *LogEntry) { l.ContentType = "asset" },
+		func(l *LogEntry) { l.Clientip = "42.24.424.24" },
+		func(l *LogEntry) { l.Host = "other.example.org" },
+		func(l *LogEntry) { l.Request = "/search?q=foo" },
+	} {
+		l := newEntry()
+		modify(l)
+		a.False(f.Match(l), "%+v", l)
+	}
+
+	l := newEntry()
+	l.Response = 304
+	l.Clientip = "192.168.1.1"
+	a.True(f.Match(l))
+}
+
+func Test_EntryFilter_EmptyMatchesAll(t *testing.T) {
+	a := assert.New(t)
+
+	f, err := NewEntryFilter("", "", nil, nil, nil, nil, nil, nil)
+	a.NoError(err)
+	a.True(f.Match(&LogEntry{Response: 500}))
+}
+
+func Test_EntryFilter_InvalidOptions(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewEntryFilter("yesterday", "", nil, nil, nil, nil, nil, nil)
+	a.Error(err)
+
+	_, err = NewEntryFilter("2016-05-29 19:00", "2016-05-29 18:00", nil, nil, nil, nil, nil, nil)
+	a.Error(err)
+
+	_, err = NewEntryFilter("", "", nil, []string{"20"}, nil, nil, nil, nil)
+	a.Error(err)
+
+	_, err = NewEntryFilter("", "", nil, nil, nil, []string{"10.0.0.0/99"}, nil, nil)
+	a.Error(err)
+}
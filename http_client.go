@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	HTTPVersionAuto = "auto"
+	HTTPVersion1    = "1.1"
+	HTTPVersion2    = "2"
+)
+
+type ClientOptions struct {
+	Timeout          time.Duration
+	ConnectTimeout   time.Duration
+	Insecure         bool
+	CAFile           string
+	ClientCert       string
+	ClientKey        string
+	HTTPVersion      string
+	Proxy            string
+	MaxIdleConns     int
+	DisableKeepAlive bool
+}
+
+// ClientFactory creates the http clients for the user simulations.
+// Every simulated user gets its own client with an own connection pool, like a browser.
+type ClientFactory struct {
+	options   ClientOptions
+	tlsConfig *tls.Config
+	proxy     func(*http.Request) (*url.URL, error)
+}
+
+func NewClientFactory(options ClientOptions) (*ClientFactory, error) {
+	switch options.HTTPVersion {
+	case "":
+		options.HTTPVersion = HTTPVersionAuto
+	case HTTPVersionAuto, HTTPVersion1, HTTPVersion2:
+	default:
+		return nil, fmt.Errorf("unsupported http version %q (expected %v, %v or %v)", options.HTTPVersion, HTTPVersionAuto, HTTPVersion1, HTTPVersion2)
+	}
+
+	tlsConfig, err := loadTLSConfig(options.Insecure, options.CAFile, options.ClientCert, options.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cf := &ClientFactory{
+		options:   options,
+		tlsConfig: tlsConfig,
+		proxy:     http.ProxyFromEnvironment,
+	}
+	if options.Proxy != "" {
+		proxyURL, err := url.Parse(options.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %q: %v", options.Proxy, err)
+		}
+		cf.proxy = http.ProxyURL(proxyURL)
+	}
+	return cf, nil
+}
+
+func (cf *ClientFactory) NewClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: cf.proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   cf.options.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       cf.tlsConfig.Clone(),
+		TLSHandshakeTimeout:   cf.options.ConnectTimeout,
+		MaxIdleConns:          cf.options.MaxIdleConns,
+		MaxIdleConnsPerHost:   cf.options.MaxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+		DisableKeepAlives:     cf.options.DisableKeepAlive,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     cf.options.HTTPVersion != HTTPVersion1,
+	}
+	if cf.options.HTTPVersion == HTTPVersion1 {
+		// a non nil, empty map disables http/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if cf.options.HTTPVersion == HTTPVersion2 {
+		// only offer h2, so that servers without http/2 fail instead of falling back
+		transport.TLSClientConfig.NextProtos = []string{"h2"}
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if cf.options.HTTPVersion == HTTPVersion2 {
+		roundTripper = http2Only{transport}
+	}
+	return &http.Client{
+		Transport: roundTripper,
+		Timeout:   cf.options.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// redirects are part of the log and replayed as their own entries
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// http2Only fails responses, which servers sent with http/1.1 after all.
+type http2Only struct {
+	transport http.RoundTripper
+}
+
+func (h http2Only) RoundTrip(request *http.Request) (*http.Response, error) {
+	resp, err := h.transport.RoundTrip(request)
+	if err == nil && resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%v answered with %v instead of HTTP/2", request.URL.Host, resp.Proto)
+	}
+	return resp, err
+}
+
+// CheckTarget rejects plain http urls for http/2, which is only negotiated over tls.
+func (cf *ClientFactory) CheckTarget(target string) error {
+	if cf.options.HTTPVersion == HTTPVersion2 && !strings.HasPrefix(strings.ToLower(target), "https://") {
+		return fmt.Errorf("--http-version %v needs https, but the target is %v", HTTPVersion2, target)
+	}
+	return nil
+}
+
+func loadTLSConfig(insecure bool, caFile, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read ca file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file %v", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("client certificate and key have to be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ClientFactory_HTTP2(t *testing.T) {
+	a := assert.New(t)
+
+	h2 := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	h1 := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer h1.Close()
+
+	cf, err := NewClientFactory(ClientOptions{HTTPVersion: HTTPVersion2, Insecure: true})
+	a.NoError(err)
+
+	resp, err := cf.NewClient().Get(h2.URL)
+	if a.NoError(err) {
+		resp.Body.Close()
+		a.Equal(2, resp.ProtoMajor)
+	}
+
+	_, err = cf.NewClient().Get(h1.URL)
+	a.Error(err)
+
+	a.NoError(cf.CheckTarget(h2.URL))
+	a.Error(cf.CheckTarget("http://www.example.org"))
+
+	cf, err = NewClientFactory(ClientOptions{HTTPVersion: HTTPVersion1, Insecure: true})
+	a.NoError(err)
+	resp, err = cf.NewClient().Get(h2.URL)
+	if a.NoError(err) {
+		resp.Body.Close()
+		a.Equal(1, resp.ProtoMajor)
+	}
+	a.NoError(cf.CheckTarget("http://www.example.org"))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterProcessor("influx", "write the replay results as influxdb line protocol to --influx-url", func(args *Args) (Processor, error) {
+		if args.InfluxURL == "" {
+			return nil, errors.New("no --influx-url given")
+		}
+		return NewInfluxDBSink(InfluxDBOptions{
+			URL:           args.InfluxURL,
+			Token:         args.InfluxToken,
+			Measurement:   args.InfluxMeasure,
+			FlushInterval: args.MetricsFlush,
+		}), nil
+	})
+}
+
+type InfluxDBOptions struct {
+	// URL of the write endpoint, e.g. http://127.0.0.1:8086/api/v2/write?org=o&bucket=b
+	// or http://127.0.0.1:8086/write?db=replay for influxdb 1.x, with nanosecond precision
+	URL string
+	// Token for the 'Authorization: Token' header of influxdb 2.x
+	Token         string
+	Measurement   string
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// InfluxDBSink writes one point per replayed entry, with the log timestamp.
+type InfluxDBSink struct {
+	*BatchSink
+	options InfluxDBOptions
+	client  *http.Client
+}
+
+func NewInfluxDBSink(options InfluxDBOptions) *InfluxDBSink {
+	if options.Measurement == "" {
+		options.Measurement = "replay"
+	}
+	is := &InfluxDBSink{
+		options: options,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	is.BatchSink = NewBatchSink("influxdb", BatchOptions{BatchSize: options.BatchSize, FlushInterval: options.FlushInterval}, is.send)
+	return is
+}
+
+func (is *InfluxDBSink) send(ctx context.Context, batch []*LogEntry) (int, error) {
+	body := &bytes.Buffer{}
+	for _, l := range batch {
+		writeInfluxLine(body, is.options.Measurement, l)
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", is.options.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if is.options.Token != "" {
+		request.Header.Set("Authorization", "Token "+is.options.Token)
+	}
+	resp, err := is.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("influxdb returned %v: %v", resp.Status, excerpt(b, 0))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return len(batch), nil
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, `\`, `\\`, "\n", `\n`)
+	influxStringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`)
+)
+
+// writeInfluxLine writes the entry as point in the line protocol, e.g.
+// replay,content_type=page,verb=GET,status=200 duration_ms=12i,ttfb_ms=3.2,error=false,request="/a" 1464538200000000000
+func writeInfluxLine(w *bytes.Buffer, measurement string, l *LogEntry) {
+	r := l.Replay
+	w.WriteString(influxMeasurementEscaper.Replace(measurement))
+	writeInfluxTag(w, "content_type", l.ContentType)
+	writeInfluxTag(w, "verb", l.Verb)
+	writeInfluxTag(w, "host", l.Host)
+	writeInfluxTag(w, "status", strconv.Itoa(r.Status))
+	writeInfluxTag(w, "error_category", string(r.ErrorCategory))
+	fmt.Fprintf(w, " duration_ms=%di,ttfb_ms=%v,response_bytes=%di,conn_reused=%v,error=%v,request=\"%v\"",
+		r.DurationMs, r.TTFBMs, r.ResponseBytes, r.ConnReused, r.Error, influxStringEscaper.Replace(l.Request))
+	if l.TraceId != "" {
+		fmt.Fprintf(w, ",trace_id=\"%v\"", l.TraceId)
+	}
+	if r.Target != "" {
+		fmt.Fprintf(w, ",target=\"%v\"", influxStringEscaper.Replace(r.Target))
+	}
+	fmt.Fprintf(w, " %d\n", l.Timestamp.UnixNano())
+}
+
+// writeInfluxTag skips empty values, because they are not allowed in the line protocol.
+func writeInfluxTag(w *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	w.WriteString(",")
+	w.WriteString(key)
+	w.WriteString("=")
+	w.WriteString(influxTagEscaper.Replace(value))
+}
+
+func (is *InfluxDBSink) PrintResults(w io.Writer) {
+	sent, failed := is.counts()
+	fmt.Fprintf(w, "written to influxdb: %v\n", sent)
+	if failed > 0 {
+		fmt.Fprintf(w, "failed to write to influxdb: %v\n", failed)
+	}
+}
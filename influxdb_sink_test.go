@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_InfluxDBSink_Write(t *testing.T) {
+	a := assert.New(t)
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("Token secret", r.Header.Get("Authorization"))
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(b)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	is := NewInfluxDBSink(InfluxDBOptions{URL: server.URL + "/api/v2/write?org=o&bucket=b", Token: "secret"})
+	replayed := &LogEntry{Request: "/a", ContentType: "page", Verb: "GET"}
+	replayed.Replay.Status = 200
+	a.NoError(is.Process(context.Background(), replayed))
+	a.NoError(is.Process(context.Background(), &LogEntry{Request: "/not-replayed"}))
+	a.NoError(is.Finish(context.Background()))
+
+	body := <-bodies
+	a.Equal(1, strings.Count(body, "\n"))
+	a.True(strings.HasPrefix(body, "replay,content_type=page,verb=GET,status=200 duration_ms=0i,"))
+	sent, failed := is.counts()
+	a.Equal(int64(1), sent)
+	a.Equal(int64(0), failed)
+}
+
+func Test_InfluxDBSink_Line(t *testing.T) {
+	a := assert.New(t)
+	l := &LogEntry{Request: `/a b?q="x"`, ContentType: "search", Host: "www.example.com", Timestamp: time.Unix(1464538200, 0)}
+	l.Replay.Status = 503
+	l.Replay.DurationMs = 12
+	l.Replay.TTFBMs = 3.5
+	l.Replay.setError(ErrorStatusMismatch, "503")
+
+	w := &bytes.Buffer{}
+	writeInfluxLine(w, "my replay", l)
+	a.Equal(`my\ replay,content_type=search,host=www.example.com,status=503,error_category=status_mismatch `+
+		`duration_ms=12i,ttfb_ms=3.5,response_bytes=0i,conn_reused=false,error=true,request="/a b?q=\"x\"" 1464538200000000000`+"\n", w.String())
+}
+
+func Test_InfluxDBSink_Escaping(t *testing.T) {
+	a := assert.New(t)
+	l := &LogEntry{Request: "/a", ContentType: `a=b,c d\e`, Timestamp: time.Unix(1464538200, 0)}
+	l.Replay.Status = 200
+
+	w := &bytes.Buffer{}
+	writeInfluxLine(w, "replay=1,x y", l)
+	a.True(strings.HasPrefix(w.String(), `replay=1\,x\ y,content_type=a\=b\,c\ d\\e,status=200 `), w.String())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterProcessor("jsonl", "write the entries as json lines to stdout", func(args *Args) (Processor, error) {
+		return NewJSONLinesProcessor(os.Stdout), nil
+	})
+	RegisterProcessor("file", "write the entries as json lines to the --output file", func(args *Args) (Processor, error) {
+		if args.Output == "" {
+			return nil, errors.New("no --output file given")
+		}
+		return NewJSONLinesFile(args.Output, int64(args.OutputMaxMB)*1024*1024, args.OutputInterval, resumePoint != nil)
+	})
+}
+
+// JSONLinesProcessor writes every entry as one line of json.
+type JSONLinesProcessor struct {
+	mutex *sync.Mutex
+	out   *bufio.Writer
+	file  *RotatingFile
+}
+
+func NewJSONLinesProcessor(w io.Writer) *JSONLinesProcessor {
+	return &JSONLinesProcessor{
+		mutex: &sync.Mutex{},
+		out:   bufio.NewWriter(w),
+	}
+}
+
+// NewJSONLinesFile writes into a file, which is rotated by size or time, if given.
+// With appendTo, the existing results are continued.
+func NewJSONLinesFile(name string, maxSize int64, interval time.Duration, appendTo bool) (*JSONLinesProcessor, error) {
+	file, err := OpenRotatingFile(name, maxSize, interval, appendTo)
+	if err != nil {
+		return nil, err
+	}
+	jp := NewJSONLinesProcessor(file)
+	jp.file = file
+	return jp, nil
+}
+
+func (jp *JSONLinesProcessor) Process(ctx context.Context, l *LogEntry) error {
+	l.wg.Wait()
+	if l.ContentType == "ignore" {
+		return nil
+	}
+	return jp.write(l)
+}
+
+// write appends v as one line of json.
+func (jp *JSONLinesProcessor) write(v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	jp.mutex.Lock()
+	defer jp.mutex.Unlock()
+	// rotate only between lines
+	if jp.file != nil && jp.file.ShouldRotate(jp.out.Buffered(), len(js)+1) {
+		if err := jp.out.Flush(); err != nil {
+			return err
+		}
+		if err := jp.file.Rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := jp.out.Write(js); err != nil {
+		return err
+	}
+	return jp.out.WriteByte('\n')
+}
+
+func (jp *JSONLinesProcessor) Finish(ctx context.Context) error {
+	jp.mutex.Lock()
+	defer jp.mutex.Unlock()
+	if err := jp.out.Flush(); err != nil {
+		return err
+	}
+	if jp.file != nil {
+		return jp.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// LogLine is an entry with its origin in the input.
+type LogLine struct {
+	Entry  *LogEntry
+	Text   string
+	source *logSource
+	line   int64
+	// start offset of the line in the uncompressed input
+	start int64
+	// timestamp of the entry in the log, because the replay sets the one of the entry
+	timestamp time.Time
+	done      bool
+}
+
+// logSource reads the entries of one input with an own parser,
+// so that inputs in different formats can be merged.
+type logSource struct {
+	index       int
+	scanner     *bufio.Scanner
+	parser      *LogParser
+	initialized bool
+	offset      int64
+	position    FilePosition
+	// pending are the read entries from index head on, ordered by line.
+	// Processed entries stay until all entries before them are processed, too.
+	pending []*LogLine
+	head    int
+	// last is the newest timestamp of the dropped lines
+	last time.Time
+}
+
+func newLogSource(index int, reader io.Reader, position FilePosition) *logSource {
+	s := &logSource{
+		index:    index,
+		parser:   NewLogParser(),
+		offset:   position.Offset,
+		position: position,
+	}
+	s.scanner = bufio.NewScanner(reader)
+	s.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		s.offset += int64(advance)
+		return advance, token, err
+	})
+	return s
+}
+
+// next returns the next entry of the input or nil at the end.
+// Lines which can not be parsed are counted as errors.
+func (s *logSource) next(errors *int) (*LogLine, error) {
+	for {
+		start := s.offset
+		if !s.scanner.Scan() {
+			return nil, s.scanner.Err()
+		}
+		text := s.scanner.Text()
+		s.position.Line++
+		s.position.Offset = s.offset
+
+		if !s.initialized {
+			if err := s.parser.ConfigureByExample(text); err != nil {
+				return nil, err
+			}
+			s.initialized = true
+		}
+		l, err := s.parser.ParseEntry(text)
+		if err != nil {
+			if args.ShowErrors {
+				log.Println(err)
+			}
+			*errors++
+			continue
+		}
+		splitHost(l)
+		// the sequence is independent of the merge order
+		l.seq = uint64(s.index)<<40 | uint64(s.position.Line)
+		line := &LogLine{Entry: l, Text: text, source: s, line: s.position.Line, start: start, timestamp: l.Timestamp}
+		s.pending = append(s.pending, line)
+		return line, nil
+	}
+}
+
+// done marks the line as processed and drops the processed lines at the head.
+func (s *logSource) done(line *LogLine) {
+	line.done = true
+	for s.head < len(s.pending) && s.pending[s.head].done {
+		if s.pending[s.head].timestamp.After(s.last) {
+			s.last = s.pending[s.head].timestamp
+		}
+		s.pending[s.head] = nil
+		s.head++
+	}
+	// reuse the slice, when most of it is dropped
+	if s.head > len(s.pending)/2 {
+		n := copy(s.pending, s.pending[s.head:])
+		s.pending = s.pending[:n]
+		s.head = 0
+	}
+}
+
+// committed is the position before the first line, which is not processed yet.
+func (s *logSource) committed() FilePosition {
+	if s.head == len(s.pending) {
+		return s.position
+	}
+	first := s.pending[s.head]
+	return FilePosition{FileName: s.position.FileName, Line: first.line - 1, Offset: first.start}
+}
+
+// LogMerger merges the entries of all inputs by their timestamp.
+// Entries are buffered for the reorder window, so that slightly unsorted inputs are sorted, too.
+type LogMerger struct {
+	// mutex guards the pending lines, which are marked as done after their replay
+	mutex     sync.Mutex
+	completed sync.WaitGroup
+	sources   []*logSource
+	heads     logLineHeap
+	buffer    logLineHeap
+	window    time.Duration
+	newest    time.Time
+	started   bool
+	// Errors is the number of lines, which could not be parsed
+	Errors int
+}
+
+func NewLogMerger(sources []*logSource, window time.Duration) *LogMerger {
+	return &LogMerger{
+		sources: sources,
+		window:  window,
+	}
+}
+
+// Next returns the oldest entry of all inputs, or nil at the end of all inputs.
+func (m *LogMerger) Next() (*LogLine, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.started {
+		m.started = true
+		for _, s := range m.sources {
+			if err := m.readHead(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for len(m.heads) > 0 {
+		if len(m.buffer) > 0 && !m.buffer[0].Entry.Timestamp.After(m.newest.Add(-m.window)) {
+			break
+		}
+		line := heap.Pop(&m.heads).(*LogLine)
+		heap.Push(&m.buffer, line)
+		if line.Entry.Timestamp.After(m.newest) {
+			m.newest = line.Entry.Timestamp
+		}
+		if err := m.readHead(line.source); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.buffer) == 0 {
+		return nil, nil
+	}
+	return heap.Pop(&m.buffer).(*LogLine), nil
+}
+
+func (m *LogMerger) readHead(s *logSource) error {
+	line, err := s.next(&m.Errors)
+	if line != nil {
+		heap.Push(&m.heads, line)
+	}
+	return err
+}
+
+// Done marks the line as processed, so that the checkpoint is after it.
+func (m *LogMerger) Done(line *LogLine) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	line.source.done(line)
+}
+
+// Complete marks the line as processed, when the replay of its entry is completed.
+// Until then, the checkpoint stays before the line.
+func (m *LogMerger) Complete(line *LogLine) {
+	m.completed.Add(1)
+	go func() {
+		defer m.completed.Done()
+		line.Entry.wg.Wait()
+		m.Done(line)
+	}()
+}
+
+// WaitCompleted waits for the replays of the lines passed to Complete, or until the context is done.
+func (m *LogMerger) WaitCompleted(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.completed.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Checkpoint returns the positions in all inputs, before the first unprocessed line of each,
+// and the newest timestamp of the processed lines before them.
+func (m *LogMerger) Checkpoint() Checkpoint {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cp := Checkpoint{}
+	for _, s := range m.sources {
+		cp.Files = append(cp.Files, s.committed())
+		if s.last.After(cp.Timestamp) {
+			cp.Timestamp = s.last
+		}
+	}
+	return cp
+}
+
+// logLineHeap orders the lines by timestamp, and by input and line for equal timestamps.
+type logLineHeap []*LogLine
+
+func (h logLineHeap) Len() int { return len(h) }
+
+func (h logLineHeap) Less(i, j int) bool {
+	ti, tj := h[i].Entry.Timestamp, h[j].Entry.Timestamp
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return h[i].Entry.seq < h[j].Entry.seq
+}
+
+func (h logLineHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *logLineHeap) Push(x interface{}) { *h = append(*h, x.(*LogLine)) }
+
+func (h *logLineHeap) Pop() interface{} {
+	old := *h
+	line := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return line
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSource(index int, lines ...string) *logSource {
+	return newLogSource(index, strings.NewReader(strings.Join(lines, "\n")), FilePosition{})
+}
+
+func testLine(second int, request string) string {
+	return fmt.Sprintf("42.24.24.24 2016-05-29T13:00:%02d+0200 GET %v HTTP/1.1 200", second, request)
+}
+
+func mergedRequests(a *assert.Assertions, m *LogMerger) []string {
+	requests := []string{}
+	for {
+		line, err := m.Next()
+		a.NoError(err)
+		if line == nil {
+			return requests
+		}
+		m.Done(line)
+		requests = append(requests, line.Entry.Request)
+	}
+}
+
+func Test_LogMerger_MergesByTimestamp(t *testing.T) {
+	a := assert.New(t)
+	defer func(a *Args) { args = a }(args)
+	args = defaultArgs()
+	m := NewLogMerger([]*logSource{
+		testSource(0, testLine(1, "/a1"), testLine(4, "/a4"), testLine(5, "/a5")),
+		testSource(1, testLine(2, "/b2"), testLine(3, "/b3"), "invalid line", testLine(6, "/b6")),
+		testSource(2),
+	}, 0)
+	a.Equal([]string{"/a1", "/b2", "/b3", "/a4", "/a5", "/b6"}, mergedRequests(a, m))
+	a.Equal(1, m.Errors)
+}
+
+func Test_LogMerger_ReorderWindow(t *testing.T) {
+	a := assert.New(t)
+	unsorted := []string{testLine(1, "/1"), testLine(3, "/3"), testLine(2, "/2"), testLine(5, "/5"), testLine(4, "/4")}
+
+	m := NewLogMerger([]*logSource{testSource(0, unsorted...)}, 0)
+	a.Equal([]string{"/1", "/3", "/2", "/5", "/4"}, mergedRequests(a, m))
+
+	m = NewLogMerger([]*logSource{testSource(0, unsorted...)}, 2*time.Second)
+	a.Equal([]string{"/1", "/2", "/3", "/4", "/5"}, mergedRequests(a, m))
+}
+
+func Test_LogMerger_CheckpointBeforePendingLines(t *testing.T) {
+	a := assert.New(t)
+	m := NewLogMerger([]*logSource{
+		testSource(0, testLine(1, "/1"), testLine(3, "/3"), testLine(2, "/2")),
+	}, 5*time.Second)
+
+	// /2 is sorted before /3, so the checkpoint stays before /3 until it is processed
+	lines := []*LogLine{}
+	for i := 0; i < 3; i++ {
+		line, err := m.Next()
+		a.NoError(err)
+		lines = append(lines, line)
+	}
+	a.Equal("/2", lines[1].Entry.Request)
+	m.Done(lines[0])
+	m.Done(lines[1])
+	a.Equal(int64(1), m.Checkpoint().Files[0].Line)
+
+	m.Done(lines[2])
+	a.Equal(int64(3), m.Checkpoint().Files[0].Line)
+}
+
+func Test_LogMerger_SequenceByOrigin(t *testing.T) {
+	a := assert.New(t)
+	m := NewLogMerger([]*logSource{
+		testSource(0, testLine(2, "/a")),
+		testSource(1, testLine(1, "/b")),
+	}, 0)
+	first, _ := m.Next()
+	second, _ := m.Next()
+	a.Equal("/b", first.Entry.Request)
+	a.Equal(uint64(1)<<40|1, first.Entry.seq)
+	a.Equal(uint64(1), second.Entry.seq)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math/rand"
+	"net"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -11,21 +13,60 @@ import (
 	"time"
 )
 
+type ReplayResult struct {
+	Target          string
+	DurationMs      int
+	DNSMs           float64
+	ConnectMs       float64
+	TLSMs           float64
+	TTFBMs          float64
+	ConnReused      bool
+	ResponseBytes   int
+	ContentEncoding string
+	Status          int
+	Error           bool
+	ErrorCategory   ErrorCategory `json:",omitempty"`
+	ErrorMessage    string        `json:",omitempty"`
+}
+
+func (r *ReplayResult) setError(category ErrorCategory, message string) {
+	r.Error = true
+	r.ErrorCategory = category
+	r.ErrorMessage = message
+}
+
+// CandidateResult is the result of the second target in a shadow replay,
+// compared to the result of the primary target.
+type CandidateResult struct {
+	ReplayResult
+	StatusMismatch bool
+	DurationDiffMs int
+	BodyMismatch   bool
+	BodyDiff       string `json:",omitempty"`
+}
+
 type LogEntry struct {
 	wg            sync.WaitGroup
+	seq           uint64
+	random        *rand.Rand
 	Clientip      string
+	Host          string
 	Verb          string
 	Request       string
 	Httpversion   string
 	Response      int
+	Referer       string
+	UserAgent     string
 	ContentType   string
 	CorrelationId string
+	TraceId       string    `json:",omitempty"`
+	SpanId        string    `json:",omitempty"`
+	ParentSpanId  string    `json:",omitempty"`
 	Timestamp     time.Time `json:"@timestamp"`
 	Replay        struct {
-		DurationMs   int
-		Error        bool
-		ErrorMessage string
-		Offset       time.Duration
+		ReplayResult
+		Offset    time.Duration
+		Candidate *CandidateResult `json:",omitempty"`
 	}
 }
 
@@ -46,14 +87,27 @@ var timePatterns = []string{
 
 var trimChars = `"[]`
 
+// vhostRegexp matches a virtual host field like in the vhost_combined log format, e.g. 'www.example.org:443'.
+var vhostRegexp = regexp.MustCompile(`^[a-zA-Z0-9-]*[a-zA-Z][a-zA-Z0-9-]*(\.[a-zA-Z0-9-]+)+(:[0-9]+)?$`)
+
+var quotedRequestRegexp = regexp.MustCompile(`^(HEAD|GET|POST|PUT|PATCH|DELETE|OPTIONS|UPGRADE) `)
+
 type LogParser struct {
 	positions   map[string]int
 	timePattern string
+	// positions within the quoted fields, which may contain spaces
+	refererPos   int
+	userAgentPos int
+	// optional position of the virtual host before the client ip
+	hostPos int
 }
 
 func NewLogParser() *LogParser {
 	return &LogParser{
-		positions: make(map[string]int),
+		positions:    make(map[string]int),
+		refererPos:   -1,
+		userAgentPos: -1,
+		hostPos:      -1,
 	}
 }
 
@@ -77,6 +131,27 @@ func (parser *LogParser) ConfigureByExample(line string) error {
 		return fmt.Errorf("can not find position for Timestamp in ine %v: %v", line, err)
 	}
 
+	for i := 0; i < parser.positions["Clientip"]; i++ {
+		if vhostRegexp.MatchString(fields[i]) {
+			parser.hostPos = i
+			break
+		}
+	}
+
+	// like in the combined log format, referer and user agent are expected as quoted fields after the request
+	quoted := quotedFields(line)
+	for i, v := range quoted {
+		if quotedRequestRegexp.MatchString(v) {
+			if i+1 < len(quoted) {
+				parser.refererPos = i + 1
+			}
+			if i+2 < len(quoted) {
+				parser.userAgentPos = i + 2
+			}
+			break
+		}
+	}
+
 	return nil
 }
 
@@ -118,9 +193,50 @@ func (parser *LogParser) ParseEntry(line string) (*LogEntry, error) {
 		}
 	}
 
+	if parser.hostPos >= 0 && parser.hostPos < len(fields) {
+		l.Host = stripPort(strings.Trim(fields[parser.hostPos], trimChars))
+	}
+
+	if parser.refererPos >= 0 || parser.userAgentPos >= 0 {
+		quoted := quotedFields(line)
+		l.Referer = quotedValue(quoted, parser.refererPos)
+		l.UserAgent = quotedValue(quoted, parser.userAgentPos)
+	}
+
 	return l, nil
 }
 
+// stripPort removes the port of a host, so that hosts match without the port.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func quotedFields(line string) []string {
+	fields := []string{}
+	for {
+		start := strings.Index(line, `"`)
+		if start < 0 {
+			return fields
+		}
+		end := strings.Index(line[start+1:], `"`)
+		if end < 0 {
+			return fields
+		}
+		fields = append(fields, line[start+1:start+1+end])
+		line = line[start+end+2:]
+	}
+}
+
+func quotedValue(quoted []string, pos int) string {
+	if pos < 0 || pos >= len(quoted) || quoted[pos] == "-" {
+		return ""
+	}
+	return quoted[pos]
+}
+
 func getPosFor(fields []string, regex string) (int, error) {
 	r := regexp.MustCompile(regex)
 	for i, v := range fields {
@@ -23,6 +23,61 @@ func Test_ParseTest(t *testing.T) {
 	a.Equal("http://www.example.org/foo/bar/bazz.pdf", l.Request)
 	a.Equal("HTTP/1.1", l.Httpversion)
 	a.Equal(206, l.Response)
+	a.Equal("https://www.google.de", l.Referer)
+	a.Equal("Mozilla/5.0 (Windows NT 6.1; rv:46.0) Gecko/20100101 Firefox/46.0", l.UserAgent)
+}
+
+func Test_ParseTest_WithoutQuotedFields(t *testing.T) {
+	a := assert.New(t)
+
+	line := `42.24.424.24 2016-05-29T13:00:00+0200 GET /foo HTTP/1.1 200`
+	parser := NewLogParser()
+	a.NoError(parser.ConfigureByExample(line))
+
+	l, err := parser.ParseEntry(line)
+	a.NoError(err)
+	a.Equal("/foo", l.Request)
+	a.Equal("", l.Referer)
+	a.Equal("", l.UserAgent)
+}
+
+func Test_ParseTest_VirtualHost(t *testing.T) {
+	a := assert.New(t)
+
+	line := `www.example.org:443 42.24.42.24 - - [29/May/2016:13:00:00 +0200] "GET /foo HTTP/1.1" 200 123 "-" "curl/7.47.0"`
+	parser := NewLogParser()
+	a.NoError(parser.ConfigureByExample(line))
+
+	l, err := parser.ParseEntry(line)
+	a.NoError(err)
+	a.Equal("www.example.org", l.Host)
+	a.Equal("/foo", l.Request)
+
+	line = `42.24.42.24 - - [29/May/2016:13:00:00 +0200] "GET /foo HTTP/1.1" 200 123 "-" "curl/7.47.0"`
+	parser = NewLogParser()
+	a.NoError(parser.ConfigureByExample(line))
+
+	l, err = parser.ParseEntry(line)
+	a.NoError(err)
+	a.Equal("", l.Host)
+}
+
+func Test_SplitHost(t *testing.T) {
+	a := assert.New(t)
+
+	l := &LogEntry{Request: "http://www.example.org:8080/foo?bar=1"}
+	splitHost(l)
+	a.Equal("www.example.org", l.Host)
+	a.Equal("/foo?bar=1", l.Request)
+
+	l = &LogEntry{Request: "https://www.example.org/foo"}
+	splitHost(l)
+	a.Equal("www.example.org", l.Host)
+	a.Equal("/foo", l.Request)
+
+	l = &LogEntry{Request: "/foo", Host: "www.example.org"}
+	splitHost(l)
+	a.Equal("www.example.org", l.Host)
 }
 
 func Test_getPosAndPatternForTime(t *testing.T) {
@@ -1,30 +1,249 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
+func init() {
+	RegisterProcessor("logstash", "send the entries as json to logstash at --logstash", func(args *Args) (Processor, error) {
+		if args.LogstashAddr == "" {
+			return nil, errors.New("no --logstash address given")
+		}
+		return NewLogstashProcessor(LogstashOptions{
+			Address:       args.LogstashAddr,
+			BatchSize:     args.LogstashBatch,
+			FlushInterval: args.LogstashFlush,
+		})
+	})
+}
+
+const (
+	LogstashUDP   = "udp"
+	LogstashTCP   = "tcp"
+	LogstashBeats = "beats"
+)
+
+// maxUDPSize is the maximum payload of an udp datagram
+const maxUDPSize = 65507
+
+type LogstashOptions struct {
+	// Address as udp://host:port, tcp://host:port or beats://host:port, host:port means udp
+	Address       string
+	BatchSize     int
+	FlushInterval time.Duration
+	// Timeout for writing a batch and reading the acks of beats
+	Timeout time.Duration
+	// MaxRetries of a batch after connection errors (tcp and beats)
+	MaxRetries int
+}
+
+// LogstashProcessor sends the entries as json in batches:
+// over udp one datagram per entry, over tcp as json lines and over beats as lumberjack v2 windows.
+// Connections are reestablished after errors and the unacknowledged entries are sent again.
 type LogstashProcessor struct {
-	conn net.Conn
+	*BatchSink
+	options      LogstashOptions
+	protocol     string
+	hostPort     string
+	conn         net.Conn
+	connMux      *sync.Mutex
+	retryBackoff time.Duration
 }
 
-func NewLogstashProcessor(hostPort string) (*LogstashProcessor, error) {
-	conn, err := net.Dial("udp", hostPort)
-	return &LogstashProcessor{
-		conn: conn,
-	}, err
+func NewLogstashProcessor(options LogstashOptions) (*LogstashProcessor, error) {
+	protocol, hostPort, err := parseLogstashAddress(options.Address)
+	if err != nil {
+		return nil, err
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	if options.MaxRetries <= 0 {
+		options.MaxRetries = 5
+	}
+
+	lp := &LogstashProcessor{
+		options:      options,
+		protocol:     protocol,
+		hostPort:     hostPort,
+		connMux:      &sync.Mutex{},
+		retryBackoff: 500 * time.Millisecond,
+	}
+	// fail early, if logstash is not there
+	if err := lp.connect(context.Background()); err != nil {
+		return nil, fmt.Errorf("can not connect to logstash at %v: %v", options.Address, err)
+	}
+	lp.BatchSink = NewBatchSink("logstash", BatchOptions{
+		BatchSize:     options.BatchSize,
+		FlushInterval: options.FlushInterval,
+		Unreplayed:    true,
+	}, lp.send)
+	return lp, nil
 }
 
-func (cp *LogstashProcessor) Process(l *LogEntry) error {
-	if l.ContentType != "ignore" {
-		if js, err := json.Marshal(l); err != nil {
-			return err
-		} else {
-			if _, err := cp.conn.Write(js); err != nil {
-				return err
-			}
-		}
+func parseLogstashAddress(address string) (protocol, hostPort string, err error) {
+	protocol, hostPort = LogstashUDP, address
+	if i := strings.Index(address, "://"); i >= 0 {
+		protocol, hostPort = address[:i], address[i+3:]
+	}
+	if protocol != LogstashUDP && protocol != LogstashTCP && protocol != LogstashBeats {
+		return "", "", fmt.Errorf("unknown logstash protocol %q in %v", protocol, address)
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		return "", "", fmt.Errorf("invalid logstash address %v: %v", address, err)
+	}
+	return protocol, hostPort, nil
+}
+
+func (lp *LogstashProcessor) connect(ctx context.Context) error {
+	network := "tcp"
+	if lp.protocol == LogstashUDP {
+		network = "udp"
+	}
+	dialer := &net.Dialer{Timeout: lp.options.Timeout}
+	conn, err := dialer.DialContext(ctx, network, lp.hostPort)
+	if err != nil {
+		return err
 	}
+	lp.setConn(conn)
 	return nil
 }
+
+// setConn replaces the connection. It is only called by the worker,
+// but locked because Finish may unblock the connection.
+func (lp *LogstashProcessor) setConn(conn net.Conn) {
+	lp.connMux.Lock()
+	defer lp.connMux.Unlock()
+	if lp.conn != nil {
+		lp.conn.Close()
+	}
+	lp.conn = conn
+}
+
+// send marshals the entries and writes them, with retries after reconnecting for tcp and beats.
+func (lp *LogstashProcessor) send(ctx context.Context, entries []*LogEntry) (int, error) {
+	batch := make([][]byte, 0, len(entries))
+	var marshalErr error
+	for _, l := range entries {
+		js, err := json.Marshal(l)
+		if err != nil {
+			marshalErr = err
+			continue
+		}
+		batch = append(batch, js)
+	}
+	var sent int
+	var err error
+	if lp.protocol == LogstashUDP {
+		sent, err = lp.sendUDP(batch)
+	} else {
+		sent, err = lp.sendStream(ctx, batch)
+	}
+	if err == nil {
+		err = marshalErr
+	}
+	return sent, err
+}
+
+// sendStream writes the batch and retries the unsent part after reconnecting.
+func (lp *LogstashProcessor) sendStream(ctx context.Context, batch [][]byte) (int, error) {
+	total := 0
+	backoff := lp.retryBackoff
+	for attempt := 0; ; attempt++ {
+		sent, err := lp.write(batch)
+		total += sent
+		batch = batch[sent:]
+		if err == nil || len(batch) == 0 {
+			return total, nil
+		}
+		if attempt >= lp.options.MaxRetries {
+			return total, fmt.Errorf("giving up after %v retries: %v", attempt, err)
+		}
+
+		lp.setConn(nil)
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if err := lp.connect(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "error connecting to logstash: %v\n", err)
+		}
+	}
+}
+
+// write sends the documents over tcp or beats
+// and returns how many of them were sent successfully.
+func (lp *LogstashProcessor) write(batch [][]byte) (int, error) {
+	if lp.conn == nil {
+		return 0, errors.New("not connected")
+	}
+	if lp.protocol == LogstashBeats {
+		return writeLumberjackWindow(lp.conn, batch, lp.options.Timeout)
+	}
+
+	lp.conn.SetWriteDeadline(time.Now().Add(lp.options.Timeout))
+	lines := make([]byte, 0, len(batch)*512)
+	for _, doc := range batch {
+		lines = append(lines, doc...)
+		lines = append(lines, '\n')
+	}
+	if _, err := lp.conn.Write(lines); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// sendUDP sends one datagram per document and returns the last error.
+func (lp *LogstashProcessor) sendUDP(batch [][]byte) (int, error) {
+	sent := 0
+	var lastErr error
+	for _, doc := range batch {
+		// udp would truncate or drop larger entries
+		if len(doc)+1 > maxUDPSize {
+			lastErr = fmt.Errorf("entry of %v bytes is too large for udp, use tcp:// or beats://", len(doc))
+			continue
+		}
+		if _, err := lp.conn.Write(append(doc, '\n')); err != nil {
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+	return sent, lastErr
+}
+
+// Finish sends the queued entries. If the context is done before, the sending is aborted.
+func (lp *LogstashProcessor) Finish(ctx context.Context) error {
+	err := lp.finish(ctx, func() {
+		lp.connMux.Lock()
+		defer lp.connMux.Unlock()
+		if lp.conn != nil {
+			// unblocks pending writes and reads
+			lp.conn.SetDeadline(time.Now())
+		}
+	})
+	lp.setConn(nil)
+	return err
+}
+
+func (lp *LogstashProcessor) PrintResults(w io.Writer) {
+	sent, failed := lp.counts()
+	fmt.Fprintf(w, "sent to logstash: %v\n", sent)
+	if failed > 0 {
+		fmt.Fprintf(w, "failed to send to logstash: %v\n", failed)
+	}
+}
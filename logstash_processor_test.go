@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LogstashProcessor_TCP(t *testing.T) {
+	a := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer listener.Close()
+
+	lines := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received := []string{}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received = append(received, scanner.Text())
+		}
+		lines <- received
+	}()
+
+	lp, err := NewLogstashProcessor(LogstashOptions{Address: "tcp://" + listener.Addr().String(), BatchSize: 2})
+	a.NoError(err)
+	for _, request := range []string{"/a", "/b", "/c"} {
+		a.NoError(lp.Process(context.Background(), &LogEntry{Request: request}))
+	}
+	a.NoError(lp.Process(context.Background(), &LogEntry{Request: "/ignored", ContentType: "ignore"}))
+	a.NoError(lp.Finish(context.Background()))
+
+	received := <-lines
+	a.Equal(3, len(received))
+	a.Contains(received[0], `"Request":"/a"`)
+	a.Contains(received[2], `"Request":"/c"`)
+	sent, _ := lp.counts()
+	a.Equal(int64(3), sent)
+}
+
+func Test_LogstashProcessor_TCPReconnect(t *testing.T) {
+	a := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer listener.Close()
+
+	dropped := make(chan struct{})
+	lines := make(chan []string)
+	go func() {
+		// the first connection is dropped in the middle of the first batch
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		bufio.NewReader(conn).ReadString('\n')
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+		close(dropped)
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received := []string{}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received = append(received, scanner.Text())
+		}
+		lines <- received
+	}()
+
+	lp, err := NewLogstashProcessor(LogstashOptions{Address: "tcp://" + listener.Addr().String(), BatchSize: 2})
+	a.NoError(err)
+	lp.retryBackoff = time.Millisecond
+	a.NoError(lp.Process(context.Background(), &LogEntry{Request: "/a"}))
+	a.NoError(lp.Process(context.Background(), &LogEntry{Request: "/b"}))
+	<-dropped
+	// wait for the reset, so that the next batch fails
+	time.Sleep(100 * time.Millisecond)
+	a.NoError(lp.Process(context.Background(), &LogEntry{Request: "/c"}))
+	a.NoError(lp.Process(context.Background(), &LogEntry{Request: "/d"}))
+	a.NoError(lp.Finish(context.Background()))
+
+	received := <-lines
+	a.Equal(2, len(received))
+	a.Contains(received[0], `"Request":"/c"`)
+	a.Contains(received[1], `"Request":"/d"`)
+	_, failed := lp.counts()
+	a.Equal(int64(0), failed)
+}
+
+func Test_LogstashProcessor_Beats(t *testing.T) {
+	a := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer listener.Close()
+
+	docs := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received := []string{}
+		for {
+			header := make([]byte, 6)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				docs <- received
+				return
+			}
+			if string(header[:2]) != "2W" {
+				t.Errorf("expected window frame, got %q", header[:2])
+				return
+			}
+			count := binary.BigEndian.Uint32(header[2:])
+			for i := uint32(1); i <= count; i++ {
+				frame := make([]byte, 10)
+				io.ReadFull(conn, frame)
+				if string(frame[:2]) != "2J" || binary.BigEndian.Uint32(frame[2:6]) != i {
+					t.Errorf("unexpected frame %q %v", frame[:2], binary.BigEndian.Uint32(frame[2:6]))
+				}
+				doc := make([]byte, binary.BigEndian.Uint32(frame[6:]))
+				io.ReadFull(conn, doc)
+				received = append(received, string(doc))
+
+				// ack every document, like a partial ack of logstash
+				ack := []byte{'2', 'A', 0, 0, 0, 0}
+				binary.BigEndian.PutUint32(ack[2:], i)
+				conn.Write(ack)
+			}
+		}
+	}()
+
+	lp, err := NewLogstashProcessor(LogstashOptions{Address: "beats://" + listener.Addr().String(), BatchSize: 2})
+	a.NoError(err)
+	for _, request := range []string{"/a", "/b", "/c"} {
+		a.NoError(lp.Process(context.Background(), &LogEntry{Request: request}))
+	}
+	a.NoError(lp.Finish(context.Background()))
+
+	received := <-docs
+	a.Equal(3, len(received))
+	a.Contains(received[1], `"Request":"/b"`)
+	sent, failed := lp.counts()
+	a.Equal(int64(3), sent)
+	a.Equal(int64(0), failed)
+}
+
+func Test_LogstashProcessor_UDPRejectsLargeEntries(t *testing.T) {
+	a := assert.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.NoError(err)
+	defer conn.Close()
+
+	lp, err := NewLogstashProcessor(LogstashOptions{Address: conn.LocalAddr().String()})
+	a.NoError(err)
+	a.NoError(lp.Process(context.Background(), &LogEntry{Request: "/" + strings.Repeat("x", maxUDPSize)}))
+	a.NoError(lp.Process(context.Background(), &LogEntry{Request: "/small"}))
+	a.NoError(lp.Finish(context.Background()))
+
+	buff := make([]byte, maxUDPSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buff)
+	a.NoError(err)
+	a.Contains(string(buff[:n]), `"Request":"/small"`)
+	a.True(strings.HasSuffix(string(buff[:n]), "}\n"))
+	sent, failed := lp.counts()
+	a.Equal(int64(1), sent)
+	a.Equal(int64(1), failed)
+}
+
+func Test_LogstashProcessor_ParseAddress(t *testing.T) {
+	a := assert.New(t)
+	tests := []struct {
+		address  string
+		protocol string
+		hostPort string
+	}{
+		{"127.0.0.1:5000", "udp", "127.0.0.1:5000"},
+		{"udp://127.0.0.1:5000", "udp", "127.0.0.1:5000"},
+		{"tcp://logstash:5000", "tcp", "logstash:5000"},
+		{"beats://logstash:5044", "beats", "logstash:5044"},
+	}
+	for _, test := range tests {
+		protocol, hostPort, err := parseLogstashAddress(test.address)
+		a.NoError(err)
+		a.Equal(test.protocol, protocol)
+		a.Equal(test.hostPort, hostPort)
+	}
+
+	_, _, err := parseLogstashAddress("http://logstash:5000")
+	a.Error(err)
+	_, _, err = parseLogstashAddress("tcp://logstash")
+	a.Error(err)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Frames of the lumberjack v2 protocol, used by beats to send events to logstash.
+const (
+	lumberjackVersion = '2'
+	lumberjackWindow  = 'W'
+	lumberjackJSON    = 'J'
+	lumberjackAck     = 'A'
+)
+
+// writeLumberjackWindow sends the json documents as one window
+// and waits until logstash has acknowledged them.
+// It returns the number of acknowledged documents, also in case of an error.
+func writeLumberjackWindow(conn net.Conn, docs [][]byte, timeout time.Duration) (int, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	w := bufio.NewWriter(conn)
+
+	header := make([]byte, 10)
+	header[0], header[1] = lumberjackVersion, lumberjackWindow
+	binary.BigEndian.PutUint32(header[2:6], uint32(len(docs)))
+	w.Write(header[:6])
+	for i, doc := range docs {
+		header[0], header[1] = lumberjackVersion, lumberjackJSON
+		binary.BigEndian.PutUint32(header[2:6], uint32(i+1))
+		binary.BigEndian.PutUint32(header[6:10], uint32(len(doc)))
+		w.Write(header)
+		w.Write(doc)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+
+	// logstash sends acks for parts of the window (or keep alives with the same sequence)
+	// until the last sequence number is acknowledged
+	acked := 0
+	ack := make([]byte, 6)
+	for acked < len(docs) {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		if _, err := io.ReadFull(conn, ack); err != nil {
+			return acked, err
+		}
+		if ack[0] != lumberjackVersion || ack[1] != lumberjackAck {
+			return acked, fmt.Errorf("unexpected lumberjack frame %q", ack[:2])
+		}
+		if seq := int(binary.BigEndian.Uint32(ack[2:])); seq > acked {
+			acked = seq
+		}
+	}
+	return acked, nil
+}
@@ -1,204 +1,196 @@
 package main
 
 import (
-	"bufio"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"github.com/alexflint/go-arg"
 	"io"
-	"log"
-	"math"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 )
 
-type Args struct {
-	LogFiles    []string `arg:"positional,help: The logfiles to replay"`
-	Verbose     bool     `arg:"-v,help: More verbose output"`
-	ShowErrors  bool     `arg:"--show-errors,help: Show errors"`
-	Limit       int      `arg:"--limit,help: Only process the first LIMIT lines"`
-	RegexIgnore string   `arg:"--regex-ignore,help: Pattern for lines to ignore (matched against the request)"`
-	RegexAssets string   `arg:"--regex-asset,help: Pattern for lines of type asset (matched against the request)"`
-	RegexAjax   string   `arg:"--regex-ajax,help: Pattern for lines of type ajax (matched against the request)"`
-	RegexSearch string   `arg:"--regex-search,help: Pattern for lines of type search (matched against the request)"`
-	BaseUrl     string   `arg:"--base-url,help: The base url to call"`
-	Username    string   `arg:"--username,help: Http Basic Auth Username"`
-	Password    string   `arg:"--password,help: Http Basic Auth Password"`
-	EsURL       string   `arg:"--es-url,help: The url of elasticsearch"`
-}
-
 var urlHostRegexp = regexp.MustCompile(`http(s?):\/\/[.:a-zA-Z0-9-]*`)
 
 type Processor interface {
-	Process(l *LogEntry) error
+	Process(ctx context.Context, l *LogEntry) error
 }
 
 var args *Args
-var RegexIgnore, RegexAssets, RegexAjax, RegexSearch *regexp.Regexp
 
 func main() {
-	args = &Args{
-		ShowErrors:  false,
-		Limit:       math.MaxInt32,
-		RegexIgnore: `healthcheck`,
-		RegexAssets: `\.jpg|\.jpeg|\.png|\.ico|\.css|\.js|\.svg|\.gif|\.pdf|\.xml|\.woff|\.eot`,
-		RegexAjax:   `jsonp_callback|\.json`,
-		RegexSearch: `\?q=|\&q=`,
-		BaseUrl:     "http://127.0.0.1",
-		Username:    "",
-		Password:    "",
-		EsURL:       "http://127.0.0.1:9200",
-	}
-	arg.MustParse(args)
-
-	RegexIgnore = regexp.MustCompile(args.RegexIgnore)
-	RegexAssets = regexp.MustCompile(args.RegexAssets)
-	RegexAjax = regexp.MustCompile(args.RegexAjax)
-	RegexSearch = regexp.MustCompile(args.RegexSearch)
-
-	indexer := NewElasticsearchIndexer(args.EsURL)
-	processors := CompoundProcessor{
-		NewReplayProcessor(args.BaseUrl, indexer, args.Username, args.Password),
-		indexer,
-	}
-
-	count, ignoreCount, errorCount := 0, 0, 0
+	name, cliArgs := "replay", os.Args[1:]
+	if len(cliArgs) > 0 {
+		if _, exist := findCommand(cliArgs[0]); exist {
+			name, cliArgs = cliArgs[0], cliArgs[1:]
+		}
+	}
+	command, _ := findCommand(name)
+
+	var p *arg.Parser
+	args, p = parseArgs(name, cliArgs)
+	if args.Seed != 0 {
+		seed = args.Seed
+	}
+	if err := initResume(args); err != nil {
+		p.Fail(err.Error())
+	}
+	if args.Manifest != "" {
+		if err := writeManifest(args.Manifest, name, args); err != nil {
+			p.Fail(err.Error())
+		}
+	}
+	command.Run(p)
+}
+
+// process reads all log files (or stdin) into the processors and prints the results.
+// On SIGINT or SIGTERM or after the --duration the reading stops
+// and the queued entries are processed within the --grace-period.
+func process(processors CompoundProcessor) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(cancel)
+	if args.Duration > 0 {
+		var cancelDuration context.CancelFunc
+		ctx, cancelDuration = context.WithTimeout(ctx, args.Duration)
+		defer cancelDuration()
+	}
+
+	sources := []*logSource{}
 	if len(args.LogFiles) > 0 {
-		for _, fileName := range args.LogFiles {
-			file, err := os.Open(fileName)
+		for i, fileName := range args.LogFiles {
+			position := FilePosition{FileName: fileName}
+			if resumePoint != nil {
+				position = resumePoint.Files[i]
+			}
+			in, file, err := openLog(fileName, position.Offset)
 			if err != nil {
 				panic(err)
 			}
 			defer file.Close()
 
-			var in io.Reader
-
-			if strings.HasSuffix(fileName, ".gz") {
-				gz, err := gzip.NewReader(file)
-				if err != nil {
-					panic(err)
-				}
-				defer gz.Close()
-				in = gz
-			} else {
-				in = file
-			}
 			fmt.Fprintf(os.Stderr, "reading from: %v\n", fileName)
-			c, ic, ec := read(in, processors)
-			count += c
-			ignoreCount += ic
-			errorCount += ec
+			sources = append(sources, newLogSource(i, in, position))
 		}
 	} else {
 		fmt.Fprintf(os.Stderr, "reading from stdin\n")
+		sources = append(sources, newLogSource(0, os.Stdin, FilePosition{}))
+	}
+	merger := NewLogMerger(sources, args.ReorderWindow)
 
-		c, ic, ec := read(os.Stdin, processors)
-		count += c
-		ignoreCount += ic
-		errorCount += ec
+	start := merger.Checkpoint()
+	if resumePoint != nil {
+		start = *resumePoint
+	}
+	checkpoints := NewCheckpointWriter(args.Checkpoint, args.CheckpointEvery, start)
+	count, ignoreCount := read(ctx, merger, processors, checkpoints)
+	errorCount := merger.Errors
+
+	// finish first, so that the results contain the queued entries
+	finishCtx, cancelFinish := context.WithTimeout(context.Background(), args.GracePeriod)
+	defer cancelFinish()
+	finishErr := processors.Finish(finishCtx, args.FlushTimeout)
+	// the checkpoint stays before entries, which replays were not completed in the grace period
+	merger.WaitCompleted(finishCtx)
+	checkpoints.Set(merger.Checkpoint())
+	if err := checkpoints.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing checkpoint: %v\n", err)
 	}
+
 	processors.PrintResults(os.Stdout)
 
 	fmt.Fprintf(os.Stderr, "Processed: %v\n", count)
 	fmt.Fprintf(os.Stderr, "Ignored: %v\n", ignoreCount)
 	fmt.Fprintf(os.Stderr, "Errors: %v\n", errorCount)
 
-	if err := processors.Finish(time.Second * 100); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err.Error())
+	if finishErr != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", finishErr.Error())
+	} else if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(os.Stderr, "stopped after %v.\n", args.Duration)
+	} else if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "stopped.\n")
 	} else {
 		fmt.Fprintf(os.Stderr, "done.\n")
 	}
 }
 
-func read(reader io.Reader, processor Processor) (count, ignoreCount, errorCount int) {
-	parser := NewLogParser()
-	initialized := false
-
-	offset := time.Duration(0)
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		if count+ignoreCount+errorCount >= args.Limit {
-			return count, ignoreCount, errorCount
-		}
-		line := scanner.Text()
-
-		if !initialized {
-			err := parser.ConfigureByExample(line)
-			if err != nil {
-				panic(err)
-			}
-			initialized = true
-		}
+// openLog opens the log file, decompressed if it ends with .gz, at the offset of the uncompressed content.
+func openLog(fileName string, offset int64) (io.Reader, *os.File, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasSuffix(fileName, ".gz") {
+		_, err := file.Seek(offset, io.SeekStart)
+		return file, file, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	// gzip can't seek, so the content before the offset is skipped
+	if _, err := io.CopyN(ioutil.Discard, gz, offset); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("can not skip to offset %v in %v: %v", offset, fileName, err)
+	}
+	return gz, file, nil
+}
 
-		l, err := parser.ParseEntry(line)
+// read processes the merged entries and updates the checkpoint, when it is due and at the end.
+func read(ctx context.Context, merger *LogMerger, processor Processor, checkpoints *CheckpointWriter) (count, ignoreCount int) {
+	for count+ignoreCount+merger.Errors < args.Limit && ctx.Err() == nil {
+		line, err := merger.Next()
 		if err != nil {
-			if args.ShowErrors {
-				log.Println(err)
-			}
-			errorCount++
-			continue
+			panic(err)
 		}
-		err = calculateFields(l)
-		if err != nil {
-			if args.ShowErrors {
-				log.Println(err)
-			}
-			errorCount++
-			continue
+		if line == nil {
+			break
 		}
+		l := line.Entry
 
-		if offset == time.Duration(0) {
-			offset = time.Since(l.Timestamp)
+		if err := processor.Process(ctx, l); err != nil {
+			if ctx.Err() != nil {
+				// the entry was not processed, so the checkpoint stays before it
+				break
+			}
+			panic(err)
 		}
-		// don't be fastster than the log
-		for time.Since(l.Timestamp) < offset {
-			time.Sleep(time.Millisecond * 100)
+		merger.Complete(line)
+		if checkpoints.Due() {
+			updateCheckpoint(checkpoints, merger.Checkpoint())
 		}
-
 		if l.ContentType == "ignore" {
 			ignoreCount++
 			continue
 		}
 		if args.Verbose {
-			fmt.Printf("\n%v\n%+v\n", line, l)
-		}
-		//fmt.Printf("%v %v %v\n", l.verb, l.ContentType, l.path)
-		l.wg.Add(1)
-		if err := processor.Process(l); err != nil {
-			panic(err)
+			fmt.Printf("\n%v\n%+v\n", line.Text, l)
 		}
 		count++
-		total := count + ignoreCount + errorCount
+		total := count + ignoreCount + merger.Errors
 		if total%10000 == 0 {
 			fmt.Fprintf(os.Stderr, "%v entries\n", total)
 		}
 	}
+	return count, ignoreCount
+}
 
-	if err := scanner.Err(); err != nil {
-		panic(err)
+func updateCheckpoint(checkpoints *CheckpointWriter, position Checkpoint) {
+	if err := checkpoints.Update(position); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing checkpoint: %v\n", err)
 	}
-	return count, ignoreCount, errorCount
 }
 
-func calculateFields(l *LogEntry) error {
-	l.Request = urlHostRegexp.ReplaceAllString(l.Request, "")
-
-	if RegexIgnore.MatchString(l.Request) || l.Response != 200 {
-		l.ContentType = "ignore"
-	} else if RegexAssets.MatchString(l.Request) {
-		l.ContentType = "asset"
-	} else if RegexSearch.MatchString(l.Request) {
-		l.ContentType = "search"
-	} else if RegexAjax.MatchString(l.Request) {
-		l.ContentType = "ajax"
-	} else {
-		l.ContentType = "page"
+// splitHost moves the host of absolute request urls without the port into the Host field.
+func splitHost(l *LogEntry) {
+	if host := urlHostRegexp.FindString(l.Request); host != "" {
+		l.Host = stripPort(host[strings.Index(host, "://")+3:])
 	}
-
-	return nil
+	l.Request = urlHostRegexp.ReplaceAllString(l.Request, "")
 }
 
 func getFirst(captures map[string][]string, key string) string {
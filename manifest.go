@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+)
+
+// version is set at build time by -ldflags "-X main.version=..."
+var version = "dev"
+
+// Manifest describes a run, so that two runs can be compared and repeated:
+// the same version, seed, options and input files give the same replay.
+type Manifest struct {
+	Version string
+	Command string
+	Seed    int64
+	Started time.Time
+	Files   []ManifestFile
+	Options map[string]interface{}
+}
+
+type ManifestFile struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+func writeManifest(fileName, command string, args *Args) error {
+	m := Manifest{
+		Version: version,
+		Command: command,
+		Seed:    seed,
+		Started: time.Now(),
+		Files:   []ManifestFile{},
+		Options: manifestOptions(args),
+	}
+	// the effective seed, also if it was chosen randomly
+	m.Options["seed"] = seed
+	for _, name := range args.LogFiles {
+		f, err := hashFile(name)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+	}
+	js, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, append(js, '\n'), 0644)
+}
+
+func hashFile(name string) (ManifestFile, error) {
+	f := ManifestFile{Name: name}
+	file, err := os.Open(name)
+	if err != nil {
+		return f, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if f.Size, err = io.Copy(h, file); err != nil {
+		return f, err
+	}
+	f.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return f, nil
+}
+
+// manifestOptions are the effective options by flag name. Secrets are recorded as hash,
+// so that runs with different credentials can be told apart.
+func manifestOptions(args *Args) map[string]interface{} {
+	options := map[string]interface{}{}
+	v := reflect.ValueOf(args).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := optionName(t.Field(i))
+		value := v.Field(i).Interface()
+		if d, isDuration := value.(time.Duration); isDuration {
+			value = d.String()
+		}
+		options[name] = maskSecret(name, value, hashSecret)
+	}
+	return options
+}
+
+// hashSecret returns a shortened sha256 of the secret, e.g. sha256:2bb80d537b1da3e3.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
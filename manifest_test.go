@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Manifest(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "manifest")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "access.log")
+	a.NoError(ioutil.WriteFile(logFile, []byte("hello\n"), 0644))
+
+	args := defaultArgs()
+	args.LogFiles = []string{logFile}
+	args.Password = "secret"
+	args.Header = []string{"X-Api-Key: secret", "X-Forwarded-For: {{.Clientip}}"}
+	args.OTLPHeader = []string{"Authorization: Bearer other"}
+	args.PassHeader = []string{"User-Agent"}
+	manifestFile := filepath.Join(dir, "manifest.json")
+	a.NoError(writeManifest(manifestFile, "replay", args))
+
+	content, err := ioutil.ReadFile(manifestFile)
+	a.NoError(err)
+	a.NotContains(string(content), `"secret"`)
+	a.NotContains(string(content), "X-Api-Key: secret")
+
+	m := Manifest{}
+	a.NoError(json.Unmarshal(content, &m))
+	a.Equal("replay", m.Command)
+	a.Equal(seed, m.Seed)
+	a.Equal([]ManifestFile{{logFile, 6, "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"}}, m.Files)
+	a.Equal("sha256:2bb80d537b1da3e3", m.Options["password"])
+	a.Equal([]interface{}{"X-Api-Key: sha256:2bb80d537b1da3e3", "X-Forwarded-For: {{.Clientip}}"}, m.Options["header"])
+	a.Equal([]interface{}{"Authorization: " + hashSecret("Bearer other")}, m.Options["otlp-header"])
+	a.NotEqual(hashSecret("secret"), hashSecret("other"))
+	a.Equal([]interface{}{"User-Agent"}, m.Options["pass-header"])
+	a.Equal("10s", m.Options["timeout"])
+	a.Equal(float64(seed), m.Options["seed"])
+
+	a.Error(writeManifest(manifestFile, "replay", &Args{LogFiles: []string{filepath.Join(dir, "missing.log")}}))
+}
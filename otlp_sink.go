@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterProcessor("otlp", "export request counts and duration histograms as otlp/http json to --otlp-url", func(args *Args) (Processor, error) {
+		if args.OTLPURL == "" {
+			return nil, errors.New("no --otlp-url given")
+		}
+		return NewOTLPSink(OTLPOptions{
+			URL:           args.OTLPURL,
+			Headers:       args.OTLPHeader,
+			FlushInterval: args.MetricsFlush,
+		})
+	})
+}
+
+// otlpDurationBounds are the upper bounds of the duration histogram buckets in ms.
+var otlpDurationBounds = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+const otlpDeltaTemporality = 1
+
+type OTLPOptions struct {
+	// URL of the metrics endpoint, e.g. http://127.0.0.1:4318/v1/metrics
+	URL string
+	// Headers as 'Name: value', e.g. for authentication
+	Headers       []string
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// OTLPSink aggregates each batch by content type and status
+// and exports it as delta sum and histogram over otlp/http with json encoding.
+type OTLPSink struct {
+	*BatchSink
+	options    OTLPOptions
+	header     http.Header
+	client     *http.Client
+	lastExport time.Time
+}
+
+func NewOTLPSink(options OTLPOptions) (*OTLPSink, error) {
+	header := http.Header{}
+	for _, h := range options.Headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid otlp header %q, expected 'Name: value'", h)
+		}
+		header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	ot := &OTLPSink{
+		options:    options,
+		header:     header,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		lastExport: time.Now(),
+	}
+	ot.BatchSink = NewBatchSink("otlp", BatchOptions{BatchSize: options.BatchSize, FlushInterval: options.FlushInterval}, ot.send)
+	return ot, nil
+}
+
+func (ot *OTLPSink) send(ctx context.Context, batch []*LogEntry) (int, error) {
+	now := time.Now()
+	js, err := json.Marshal(otlpMetrics(batch, ot.lastExport, now))
+	if err != nil {
+		return 0, err
+	}
+	ot.lastExport = now
+
+	request, err := http.NewRequestWithContext(ctx, "POST", ot.options.URL, bytes.NewReader(js))
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range ot.header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := ot.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("otlp endpoint returned %v: %v", resp.Status, excerpt(b, 0))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return len(batch), nil
+}
+
+// The types follow the json encoding of the ExportMetricsServiceRequest,
+// in which 64 bit integers are strings.
+type (
+	otlpAttribute struct {
+		Key   string            `json:"key"`
+		Value map[string]string `json:"value"`
+	}
+	otlpNumberPoint struct {
+		Attributes        []otlpAttribute `json:"attributes"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		AsInt             string          `json:"asInt"`
+	}
+	otlpHistogramPoint struct {
+		Attributes        []otlpAttribute `json:"attributes"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		Count             string          `json:"count"`
+		Sum               float64         `json:"sum"`
+		Min               float64         `json:"min"`
+		Max               float64         `json:"max"`
+		BucketCounts      []string        `json:"bucketCounts"`
+		ExplicitBounds    []float64       `json:"explicitBounds"`
+	}
+	otlpSum struct {
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	}
+	otlpHistogram struct {
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	}
+	otlpMetric struct {
+		Name      string         `json:"name"`
+		Unit      string         `json:"unit"`
+		Sum       *otlpSum       `json:"sum,omitempty"`
+		Histogram *otlpHistogram `json:"histogram,omitempty"`
+	}
+)
+
+type otlpSeries struct {
+	contentType string
+	status      int
+	count       int
+	errors      int
+	histogram   otlpHistogramPoint
+	buckets     []int
+}
+
+// otlpMetrics aggregates the entries into the metrics
+// replaybench.requests, replaybench.errors and replaybench.duration.
+func otlpMetrics(batch []*LogEntry, start, end time.Time) map[string]interface{} {
+	series := map[string]*otlpSeries{}
+	for _, l := range batch {
+		key := l.ContentType + "/" + strconv.Itoa(l.Replay.Status)
+		s, exists := series[key]
+		if !exists {
+			s = &otlpSeries{contentType: l.ContentType, status: l.Replay.Status, buckets: make([]int, len(otlpDurationBounds)+1)}
+			series[key] = s
+		}
+		d := float64(l.Replay.DurationMs)
+		if s.count == 0 || d < s.histogram.Min {
+			s.histogram.Min = d
+		}
+		if d > s.histogram.Max {
+			s.histogram.Max = d
+		}
+		s.histogram.Sum += d
+		s.count++
+		if l.Replay.Error {
+			s.errors++
+		}
+		s.buckets[sort.SearchFloat64s(otlpDurationBounds, d)]++
+	}
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	startNano, endNano := strconv.FormatInt(start.UnixNano(), 10), strconv.FormatInt(end.UnixNano(), 10)
+	requests := otlpMetric{Name: "replaybench.requests", Unit: "{request}",
+		Sum: &otlpSum{AggregationTemporality: otlpDeltaTemporality, IsMonotonic: true}}
+	failures := otlpMetric{Name: "replaybench.errors", Unit: "{request}",
+		Sum: &otlpSum{AggregationTemporality: otlpDeltaTemporality, IsMonotonic: true}}
+	duration := otlpMetric{Name: "replaybench.duration", Unit: "ms",
+		Histogram: &otlpHistogram{AggregationTemporality: otlpDeltaTemporality}}
+
+	for _, key := range keys {
+		s := series[key]
+		attributes := []otlpAttribute{
+			{Key: "content_type", Value: map[string]string{"stringValue": s.contentType}},
+			{Key: "http.response.status_code", Value: map[string]string{"intValue": strconv.Itoa(s.status)}},
+		}
+		requests.Sum.DataPoints = append(requests.Sum.DataPoints, otlpNumberPoint{attributes, startNano, endNano, strconv.Itoa(s.count)})
+		if s.errors > 0 {
+			failures.Sum.DataPoints = append(failures.Sum.DataPoints, otlpNumberPoint{attributes, startNano, endNano, strconv.Itoa(s.errors)})
+		}
+		h := s.histogram
+		h.Attributes, h.StartTimeUnixNano, h.TimeUnixNano = attributes, startNano, endNano
+		h.Count = strconv.Itoa(s.count)
+		h.ExplicitBounds = otlpDurationBounds
+		for _, c := range s.buckets {
+			h.BucketCounts = append(h.BucketCounts, strconv.Itoa(c))
+		}
+		duration.Histogram.DataPoints = append(duration.Histogram.DataPoints, h)
+	}
+
+	metrics := []otlpMetric{requests, duration}
+	if len(failures.Sum.DataPoints) > 0 {
+		metrics = append(metrics, failures)
+	}
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: map[string]string{"stringValue": "replaybench"}}},
+			},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]string{"name": "replaybench"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+func (ot *OTLPSink) PrintResults(w io.Writer) {
+	sent, failed := ot.counts()
+	fmt.Fprintf(w, "exported to otlp: %v\n", sent)
+	if failed > 0 {
+		fmt.Fprintf(w, "failed to export to otlp: %v\n", failed)
+	}
+}
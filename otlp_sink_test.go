@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_OTLPSink_Export(t *testing.T) {
+	a := assert.New(t)
+	requests := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("/v1/metrics", r.URL.Path)
+		a.Equal("application/json", r.Header.Get("Content-Type"))
+		a.Equal("Bearer secret", r.Header.Get("Authorization"))
+		m := map[string]interface{}{}
+		a.NoError(json.NewDecoder(r.Body).Decode(&m))
+		requests <- m
+	}))
+	defer server.Close()
+
+	ot, err := NewOTLPSink(OTLPOptions{URL: server.URL + "/v1/metrics", Headers: []string{"Authorization: Bearer secret"}})
+	a.NoError(err)
+	for _, d := range []int{3, 30, 300} {
+		l := &LogEntry{ContentType: "page"}
+		l.Replay.Status = 200
+		l.Replay.DurationMs = d
+		a.NoError(ot.Process(context.Background(), l))
+	}
+	a.NoError(ot.Finish(context.Background()))
+
+	m := <-requests
+	scope := m["resourceMetrics"].([]interface{})[0].(map[string]interface{})["scopeMetrics"].([]interface{})[0].(map[string]interface{})
+	metrics := scope["metrics"].([]interface{})
+	a.Equal(2, len(metrics))
+
+	count := metrics[0].(map[string]interface{})
+	a.Equal("replaybench.requests", count["name"])
+	point := count["sum"].(map[string]interface{})["dataPoints"].([]interface{})[0].(map[string]interface{})
+	a.Equal("3", point["asInt"])
+
+	histogram := metrics[1].(map[string]interface{})
+	a.Equal("replaybench.duration", histogram["name"])
+	point = histogram["histogram"].(map[string]interface{})["dataPoints"].([]interface{})[0].(map[string]interface{})
+	a.Equal("3", point["count"])
+	a.Equal(333.0, point["sum"])
+	a.Equal(3.0, point["min"])
+	a.Equal(300.0, point["max"])
+	a.Equal([]interface{}{"1", "0", "0", "1", "0", "0", "1", "0", "0", "0", "0", "0"}, point["bucketCounts"])
+}
+
+func Test_OTLPSink_InvalidHeader(t *testing.T) {
+	_, err := NewOTLPSink(OTLPOptions{URL: "http://127.0.0.1:4318/v1/metrics", Headers: []string{"Authorization"}})
+	assert.Error(t, err)
+}
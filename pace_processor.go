@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+func init() {
+	RegisterProcessor("pace", "don't process the entries faster than they were logged", func(args *Args) (Processor, error) {
+		pp := &PaceProcessor{}
+		if resumePoint != nil {
+			pp.resumeAt = resumePoint.Timestamp
+		}
+		return pp, nil
+	})
+}
+
+// PaceProcessor blocks the reading, so that the following processors
+// get the entries in the same pace as they were logged.
+// After a resume, the pace continues from the log timestamp of the checkpoint.
+type PaceProcessor struct {
+	offset   time.Duration
+	resumeAt time.Time
+}
+
+func (pp *PaceProcessor) Process(ctx context.Context, l *LogEntry) error {
+	if l.ContentType == "ignore" {
+		return nil
+	}
+	if pp.offset == time.Duration(0) {
+		pp.offset = time.Since(l.Timestamp)
+		if !pp.resumeAt.IsZero() {
+			pp.offset = time.Since(pp.resumeAt)
+		}
+	}
+	// don't be fastster than the log
+	for time.Since(l.Timestamp) < pp.offset {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+	return nil
+}
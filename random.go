@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// seed is the base of all random decisions, given by --seed or chosen randomly.
+// With the same seed, the entries of a log get the same sampling decisions and correlation ids.
+var seed = time.Now().UnixNano()
+
+// splitMix64 is a small random source, which is cheap to create for every entry.
+type splitMix64 uint64
+
+func (s *splitMix64) Uint64() uint64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *splitMix64) Seed(seed int64) {
+	*s = splitMix64(seed)
+}
+
+// newRand returns a random generator for the sequence number of an entry,
+// which is independent of the order in which the entries are processed.
+func newRand(sequence uint64) *rand.Rand {
+	s := splitMix64(uint64(seed))
+	s = splitMix64(s.Uint64() ^ sequence)
+	return rand.New(&s)
+}
+
+// Rand is the random generator of the entry, derived from its sequence number in the input.
+func (l *LogEntry) Rand() *rand.Rand {
+	if l.random == nil {
+		l.random = newRand(l.seq)
+	}
+	return l.random
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ProcessorFactory creates a processor from its options in the args.
+type ProcessorFactory func(args *Args) (Processor, error)
+
+type processorDefinition struct {
+	name        string
+	description string
+	factory     ProcessorFactory
+}
+
+var processorRegistry = make(map[string]*processorDefinition)
+
+// RegisterProcessor makes a processor available for the --pipeline option.
+// It is meant to be called from the init function of the processor's file.
+func RegisterProcessor(name, description string, factory ProcessorFactory) {
+	if _, exist := processorRegistry[name]; exist {
+		panic("processor registered twice: " + name)
+	}
+	processorRegistry[name] = &processorDefinition{
+		name:        name,
+		description: description,
+		factory:     factory,
+	}
+}
+
+// NewPipeline creates the processors in the given order.
+func NewPipeline(names []string, args *Args) (CompoundProcessor, error) {
+	names = splitValues(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("the pipeline is empty")
+	}
+
+	pipeline := CompoundProcessor{}
+	for _, name := range names {
+		definition, exist := processorRegistry[name]
+		if !exist {
+			return nil, fmt.Errorf("unknown processor %q in pipeline (available: %v)", name, strings.Join(processorNames(), ", "))
+		}
+		p, err := definition.factory(args)
+		if err != nil {
+			return nil, fmt.Errorf("error creating processor %v: %v", name, err)
+		}
+		pipeline = append(pipeline, p)
+	}
+	return pipeline, nil
+}
+
+func processorNames() []string {
+	names := make([]string, 0, len(processorRegistry))
+	for name := range processorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printProcessors(w io.Writer) {
+	for _, name := range processorNames() {
+		fmt.Fprintf(w, "  %-10v %v\n", name, processorRegistry[name].description)
+	}
+}
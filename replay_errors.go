@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+type ErrorCategory string
+
+const (
+	ErrorTimeout            ErrorCategory = "timeout"
+	ErrorConnectionRefused  ErrorCategory = "connection_refused"
+	ErrorConnection         ErrorCategory = "connection"
+	ErrorDNS                ErrorCategory = "dns"
+	ErrorTLS                ErrorCategory = "tls"
+	ErrorStatusMismatch     ErrorCategory = "status_mismatch"
+	ErrorUnexpectedRedirect ErrorCategory = "unexpected_redirect"
+	ErrorBodyRead           ErrorCategory = "body_read"
+	ErrorInvalidRequest     ErrorCategory = "invalid_request"
+	ErrorAuth               ErrorCategory = "auth"
+	ErrorCanceled           ErrorCategory = "canceled"
+)
+
+func isRedirect(status int) bool {
+	return status == 301 || status == 302 || status == 303 || status == 307 || status == 308
+}
+
+// classifyError maps an error of the http client to its category.
+func classifyError(err error) ErrorCategory {
+	if errors.Is(err, context.Canceled) {
+		return ErrorCanceled
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorDNS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorConnectionRefused
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorTimeout
+	}
+	if isTLSError(err) {
+		return ErrorTLS
+	}
+	return ErrorConnection
+}
+
+func isTLSError(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &certificateInvalidErr) {
+		return true
+	}
+	// alerts from the server are not exported as types
+	return strings.Contains(err.Error(), "tls: ")
+}
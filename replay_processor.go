@@ -1,61 +1,74 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
-	"time"
 )
 
+func init() {
+	RegisterProcessor("replay", "replay the requests against the base url (and the candidate url)", newReplayProcessorFromArgs)
+}
+
+type ReplayOptions struct {
+	Client       *ClientFactory
+	BaseURL      string
+	Auth         AuthProvider
+	PreserveHost bool
+	Headers      *RequestHeaders
+	CandidateURL string
+	BodyComparer *BodyComparer
+	Trace        *TraceContext
+}
+
 type ReplayProcessor struct {
-	baseURL        string
-	username       string
-	password       string
+	options        ReplayOptions
 	fanout         chan *LogEntry
 	userSimulation map[string]*UserSimulation
 	mux            *sync.Mutex
-	shouldFinish   chan bool
-	workerDone     chan bool
-	log            Processor
+	ctx            context.Context
+	cancel         context.CancelFunc
+	workerDone     chan struct{}
 }
 
-func NewReplayProcessor(baseURL string, log Processor, username, password string) *ReplayProcessor {
+func NewReplayProcessor(options ReplayOptions) *ReplayProcessor {
+	ctx, cancel := context.WithCancel(context.Background())
 	rp := &ReplayProcessor{
-		baseURL:        baseURL,
-		username:       username,
-		password:       password,
+		options:        options,
 		fanout:         make(chan *LogEntry, 100),
 		userSimulation: make(map[string]*UserSimulation),
 		mux:            &sync.Mutex{},
-		shouldFinish:   make(chan bool),
-		workerDone:     make(chan bool),
-		log:            log,
+		ctx:            ctx,
+		cancel:         cancel,
+		workerDone:     make(chan struct{}),
 	}
 	go rp.startWorker()
 	return rp
 }
 
-func (rp *ReplayProcessor) Process(l *LogEntry) error {
-	rp.fanout <- l
-	return nil
+func (rp *ReplayProcessor) Process(ctx context.Context, l *LogEntry) error {
+	if l.ContentType == "ignore" {
+		return nil
+	}
+	// following processors wait for the replay to be done
+	l.wg.Add(1)
+	select {
+	case rp.fanout <- l:
+		return nil
+	case <-ctx.Done():
+		l.wg.Done()
+		return ctx.Err()
+	}
 }
 
 func (rp *ReplayProcessor) startWorker() {
-loop:
-	for {
-		select {
-		case l := <-rp.fanout:
-			if l.ContentType == "ignore" {
-				l.wg.Done()
-				continue
-			}
-			us := rp.getUserSimulation(l.Clientip)
-			us.Process(l)
-		case <-rp.shouldFinish:
-			break loop
-		}
+	for l := range rp.fanout {
+		rp.getUserSimulation(l.Clientip).Process(l)
 	}
-	rp.workerDone <- true
+	close(rp.workerDone)
 }
 
 func (rp *ReplayProcessor) getUserSimulation(clientIp string) *UserSimulation {
@@ -65,7 +78,7 @@ func (rp *ReplayProcessor) getUserSimulation(clientIp string) *UserSimulation {
 	us, exist := rp.userSimulation[clientIp]
 	if !exist {
 		fmt.Fprintf(os.Stderr, "started user simulation %v\n", clientIp)
-		us = newUserSimulation(rp.baseURL, rp.log, rp.username, rp.password)
+		us = newUserSimulation(rp.ctx, clientIp, rp.options)
 		rp.userSimulation[clientIp] = us
 		// cleanup old
 		for k, v := range rp.userSimulation {
@@ -79,18 +92,80 @@ func (rp *ReplayProcessor) getUserSimulation(clientIp string) *UserSimulation {
 	return us
 }
 
-func (rp *ReplayProcessor) Finish() chan bool {
-	done := make(chan bool)
+// Finish replays the queued entries. If the context is done before,
+// the running requests are aborted and the remaining entries are marked as canceled.
+func (rp *ReplayProcessor) Finish(ctx context.Context) error {
+	close(rp.fanout)
+	done := make(chan struct{})
 	go func() {
-		for len(rp.fanout) > 0 {
-			time.Sleep(10 * time.Millisecond)
-		}
-		close(rp.shouldFinish) // close does a broadcast
-		for _, v := range rp.userSimulation {
-			simC := v.Finish()
-			<-simC
+		<-rp.workerDone
+		for _, us := range rp.userSimulation {
+			us.Finish()
 		}
-		done <- true
+		close(done)
 	}()
-	return done
+	return waitOrCancel(ctx, done, rp.cancel)
+}
+
+func newReplayProcessorFromArgs(args *Args) (Processor, error) {
+	clientFactory, err := NewClientFactory(ClientOptions{
+		Timeout:          args.Timeout,
+		ConnectTimeout:   args.ConnectTimeout,
+		Insecure:         args.Insecure,
+		CAFile:           args.CAFile,
+		ClientCert:       args.ClientCert,
+		ClientKey:        args.ClientKey,
+		HTTPVersion:      args.HTTPVersion,
+		Proxy:            args.Proxy,
+		MaxIdleConns:     args.MaxIdleConns,
+		DisableKeepAlive: args.DisableKeepAlive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	headers, err := NewRequestHeaders(args.Header, args.PassHeader)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newAuthProvider(args, clientFactory)
+	if err != nil {
+		return nil, err
+	}
+	trace, err := NewTraceContext(args.TraceContext, args.TraceState, args.TraceB3)
+	if err != nil {
+		return nil, err
+	}
+	options := ReplayOptions{
+		Client:       clientFactory,
+		BaseURL:      strings.TrimRight(args.BaseUrl, "/"),
+		Auth:         auth,
+		PreserveHost: args.PreserveHost,
+		Headers:      headers,
+		CandidateURL: strings.TrimRight(args.CandidateUrl, "/"),
+		Trace:        trace,
+	}
+	targets := []string{options.BaseURL, options.CandidateURL}
+	for _, mapping := range args.MapHost {
+		if parts := strings.SplitN(mapping, "=", 2); len(parts) == 2 {
+			targets = append(targets, parts[1])
+		}
+	}
+	for _, target := range targets {
+		if target == "" {
+			continue
+		}
+		if err := clientFactory.CheckTarget(target); err != nil {
+			return nil, err
+		}
+	}
+	if args.VerifyBodies {
+		if options.CandidateURL == "" {
+			return nil, errors.New("--verify-bodies needs a --candidate-url to compare with")
+		}
+		options.BodyComparer, err = NewBodyComparer(args.IgnoreJSON, args.MaskRegex)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewReplayProcessor(options), nil
 }
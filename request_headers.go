@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// headers of the log, which can be passed through to the replayed request
+var passThroughHeaders = map[string]func(l *LogEntry) string{
+	"User-Agent": func(l *LogEntry) string { return l.UserAgent },
+	"Referer":    func(l *LogEntry) string { return l.Referer },
+}
+
+type headerRule struct {
+	name     string
+	value    string
+	template *template.Template
+}
+
+// RequestHeaders sets the additional headers of a replayed request.
+type RequestHeaders struct {
+	rules       []headerRule
+	passThrough []string
+}
+
+// NewRequestHeaders creates the header rules from values like 'X-Api-Key: secret'.
+// Values containing {{ }} are executed as go template with the LogEntry as data,
+// e.g. 'X-Forwarded-For: {{.Clientip}}'.
+func NewRequestHeaders(headers, passThrough []string) (*RequestHeaders, error) {
+	rh := &RequestHeaders{}
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected 'Name: value'", h)
+		}
+		rule := headerRule{
+			name:  http.CanonicalHeaderKey(strings.TrimSpace(parts[0])),
+			value: strings.TrimSpace(parts[1]),
+		}
+		if strings.Contains(rule.value, "{{") {
+			t, err := template.New(rule.name).Option("missingkey=error").Parse(rule.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid header template %q: %v", h, err)
+			}
+			rule.template = t
+		}
+		rh.rules = append(rh.rules, rule)
+	}
+
+	for _, name := range splitValues(passThrough) {
+		name = http.CanonicalHeaderKey(name)
+		if _, exist := passThroughHeaders[name]; !exist {
+			return nil, fmt.Errorf("header %q is not captured from the log", name)
+		}
+		rh.passThrough = append(rh.passThrough, name)
+	}
+	return rh, nil
+}
+
+func (rh *RequestHeaders) Apply(request *http.Request, l *LogEntry) error {
+	for _, name := range rh.passThrough {
+		if value := passThroughHeaders[name](l); value != "" {
+			request.Header.Set(name, value)
+		}
+	}
+
+	for _, rule := range rh.rules {
+		value := rule.value
+		if rule.template != nil {
+			buff := &bytes.Buffer{}
+			if err := rule.template.Execute(buff, l); err != nil {
+				return fmt.Errorf("error in template for header %v: %v", rule.name, err)
+			}
+			value = buff.String()
+		}
+		if rule.name == "Host" {
+			request.Host = value
+		} else {
+			request.Header.Set(rule.name, value)
+		}
+	}
+	return nil
+}
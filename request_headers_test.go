@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func Test_RequestHeaders_Apply(t *testing.T) {
+	a := assert.New(t)
+
+	rh, err := NewRequestHeaders(
+		[]string{"x-api-key: secret", "X-Forwarded-For: {{.Clientip}}", "Host: staging.example.org"},
+		[]string{"user-agent,Referer"})
+	a.NoError(err)
+
+	l := &LogEntry{Clientip: "42.24.24.24", UserAgent: "Mozilla/5.0", Referer: ""}
+	request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	a.NoError(rh.Apply(request, l))
+
+	a.Equal("secret", request.Header.Get("X-Api-Key"))
+	a.Equal("42.24.24.24", request.Header.Get("X-Forwarded-For"))
+	a.Equal("Mozilla/5.0", request.Header.Get("User-Agent"))
+	a.Equal("", request.Header.Get("Referer"))
+	a.Equal("staging.example.org", request.Host)
+}
+
+func Test_RequestHeaders_InvalidOptions(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewRequestHeaders([]string{"no value"}, nil)
+	a.Error(err)
+
+	_, err = NewRequestHeaders([]string{"X-Foo: {{.Clientip"}, nil)
+	a.Error(err)
+
+	_, err = NewRequestHeaders(nil, []string{"Accept-Language"})
+	a.Error(err)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// requestTrace collects the connection timings of one request.
+// The callbacks may run in the dial goroutine, also after the request has returned.
+type requestTrace struct {
+	mux          sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	connReused   bool
+}
+
+func newRequestTrace(request *http.Request) (*requestTrace, *http.Request) {
+	rt := &requestTrace{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { rt.now(&rt.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { rt.now(&rt.dnsDone) },
+		ConnectStart: func(network, addr string) {
+			rt.mux.Lock()
+			defer rt.mux.Unlock()
+			if rt.connectStart.IsZero() {
+				rt.connectStart = time.Now()
+			}
+		},
+		ConnectDone:       func(network, addr string, err error) { rt.now(&rt.connectDone) },
+		TLSHandshakeStart: func() { rt.now(&rt.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { rt.now(&rt.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.mux.Lock()
+			defer rt.mux.Unlock()
+			rt.connReused = info.Reused
+		},
+		GotFirstResponseByte: func() { rt.now(&rt.firstByte) },
+	}
+	return rt, request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+}
+
+func (rt *requestTrace) now(t *time.Time) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	*t = time.Now()
+}
+
+func (rt *requestTrace) fill(result *ReplayResult) {
+	rt.mux.Lock()
+	defer rt.mux.Unlock()
+	result.DNSMs = millis(rt.dnsStart, rt.dnsDone)
+	result.ConnectMs = millis(rt.connectStart, rt.connectDone)
+	result.TLSMs = millis(rt.tlsStart, rt.tlsDone)
+	result.TTFBMs = millis(rt.start, rt.firstByte)
+	result.ConnReused = rt.connReused
+}
+
+func millis(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return float64(end.Sub(start).Nanoseconds()) / 1000000
+}
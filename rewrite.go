@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterProcessor("rewrite", "rewrite the requests by the --rewrite-path, --drop-param, --set-param and --map-host options", func(args *Args) (Processor, error) {
+		return NewURLRewriter(args.RewritePath, args.DropParam, args.SetParam, args.MapHost)
+	})
+}
+
+type pathRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+type queryParam struct {
+	name  string
+	value string
+}
+
+// URLRewriter modifies the request of an entry before it is replayed
+// and selects the target base url by the original host.
+type URLRewriter struct {
+	pathRules  []pathRule
+	dropParams []string
+	setParams  []queryParam
+	hostMap    map[string]string
+}
+
+// NewURLRewriter creates a rewriter from the command line values:
+// path rules as 'regex=>replacement', parameters to drop as names or glob patterns (e.g. 'utm_*'),
+// parameters to set as 'name=value' and host mappings as 'host=base-url'.
+func NewURLRewriter(pathRules, dropParams, setParams, hostMap []string) (*URLRewriter, error) {
+	r := &URLRewriter{
+		dropParams: splitValues(dropParams),
+		hostMap:    make(map[string]string),
+	}
+
+	for _, rule := range pathRules {
+		parts := strings.SplitN(rule, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid rewrite rule %q, expected 'regex=>replacement'", rule)
+		}
+		pattern, err := regexp.Compile(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite rule %q: %v", rule, err)
+		}
+		r.pathRules = append(r.pathRules, pathRule{pattern: pattern, replacement: parts[1]})
+	}
+
+	for _, pattern := range r.dropParams {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid parameter pattern %q: %v", pattern, err)
+		}
+	}
+
+	for _, param := range setParams {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid parameter %q, expected 'name=value'", param)
+		}
+		r.setParams = append(r.setParams, queryParam{name: parts[0], value: parts[1]})
+	}
+
+	for _, mapping := range hostMap {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid host mapping %q, expected 'host=base-url'", mapping)
+		}
+		if _, err := url.Parse(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid host mapping %q: %v", mapping, err)
+		}
+		r.hostMap[strings.ToLower(parts[0])] = strings.TrimRight(parts[1], "/")
+	}
+
+	return r, nil
+}
+
+func (r *URLRewriter) Process(ctx context.Context, l *LogEntry) error {
+	if l.ContentType != "ignore" {
+		r.Rewrite(l)
+	}
+	return nil
+}
+
+// Rewrite applies the rules to the request of the entry
+// and stores the base url for the host of the entry, if one is mapped.
+func (r *URLRewriter) Rewrite(l *LogEntry) {
+	for _, rule := range r.pathRules {
+		l.Request = rule.pattern.ReplaceAllString(l.Request, rule.replacement)
+	}
+
+	if len(r.dropParams) > 0 || len(r.setParams) > 0 {
+		l.Request = r.rewriteQuery(l.Request)
+	}
+
+	if target, exist := r.hostMap[strings.ToLower(l.Host)]; exist {
+		l.Replay.Target = target
+	}
+}
+
+// rewriteQuery modifies the query parameters, but keeps their order and encoding
+// so that cache keys on the target stay the same.
+func (r *URLRewriter) rewriteQuery(request string) string {
+	pathPart, query := request, ""
+	if i := strings.Index(request, "?"); i >= 0 {
+		pathPart, query = request[:i], request[i+1:]
+	}
+
+	params := []string{}
+	set := make(map[string]bool)
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		name := param
+		if i := strings.Index(param, "="); i >= 0 {
+			name = param[:i]
+		}
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if r.shouldDrop(name) {
+			continue
+		}
+		if value, exist := r.setValue(name); exist {
+			if set[name] {
+				continue
+			}
+			set[name] = true
+			param = url.QueryEscape(name) + "=" + url.QueryEscape(value)
+		}
+		params = append(params, param)
+	}
+	for _, p := range r.setParams {
+		if !set[p.name] {
+			set[p.name] = true
+			params = append(params, url.QueryEscape(p.name)+"="+url.QueryEscape(p.value))
+		}
+	}
+
+	if len(params) == 0 {
+		return pathPart
+	}
+	return pathPart + "?" + strings.Join(params, "&")
+}
+
+func (r *URLRewriter) shouldDrop(name string) bool {
+	for _, pattern := range r.dropParams {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *URLRewriter) setValue(name string) (string, bool) {
+	for _, p := range r.setParams {
+		if p.name == name {
+			return p.value, true
+		}
+	}
+	return "", false
+}
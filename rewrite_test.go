@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_URLRewriter_Rewrite(t *testing.T) {
+	a := assert.New(t)
+
+	r, err := NewURLRewriter(
+		[]string{`^/shop/(.*)$=>/v2/shop/$1`},
+		[]string{"utm_*,_"},
+		[]string{"debug=true", "lang=de"},
+		[]string{"www.example.org=http://staging-a.local/", "shop.example.org=http://staging-b.local"})
+	a.NoError(err)
+
+	l := &LogEntry{Host: "WWW.example.org", Request: "/shop/item?id=4%2F2&utm_source=news&_=123456&lang=en&lang=fr"}
+	r.Rewrite(l)
+	a.Equal("/v2/shop/item?id=4%2F2&lang=de&debug=true", l.Request)
+	a.Equal("http://staging-a.local", l.Replay.Target)
+
+	l = &LogEntry{Host: "shop.example.org", Request: "/?_=1"}
+	r.Rewrite(l)
+	a.Equal("/?debug=true&lang=de", l.Request)
+	a.Equal("http://staging-b.local", l.Replay.Target)
+
+	l = &LogEntry{Host: "unknown.example.org", Request: "/foo"}
+	r.Rewrite(l)
+	a.Equal("/foo?debug=true&lang=de", l.Request)
+	a.Equal("", l.Replay.Target)
+}
+
+func Test_URLRewriter_Empty(t *testing.T) {
+	a := assert.New(t)
+
+	r, err := NewURLRewriter(nil, nil, nil, nil)
+	a.NoError(err)
+
+	l := &LogEntry{Host: "www.example.org", Request: "/foo?b=1&a=2"}
+	r.Rewrite(l)
+	a.Equal("/foo?b=1&a=2", l.Request)
+	a.Equal("", l.Replay.Target)
+}
+
+func Test_URLRewriter_InvalidOptions(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewURLRewriter([]string{"/foo"}, nil, nil, nil)
+	a.Error(err)
+
+	_, err = NewURLRewriter([]string{"(=>x"}, nil, nil, nil)
+	a.Error(err)
+
+	_, err = NewURLRewriter(nil, []string{"["}, nil, nil)
+	a.Error(err)
+
+	_, err = NewURLRewriter(nil, nil, []string{"foo"}, nil)
+	a.Error(err)
+
+	_, err = NewURLRewriter(nil, nil, nil, []string{"www.example.org"})
+	a.Error(err)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RotatingFile writes into a new file after a maximum size or time.
+// Files ending with .gz are gzip compressed.
+// If rotation is enabled, the files are numbered, e.g. results-0001.jsonl.gz.
+type RotatingFile struct {
+	name     string
+	maxSize  int64
+	interval time.Duration
+	appendTo bool
+	sequence int
+	file     *os.File
+	gz       *gzip.Writer
+	out      io.Writer
+	written  int64
+	opened   time.Time
+}
+
+// OpenRotatingFile creates the first file. A maxSize (uncompressed bytes) or interval of 0 disables the rotation by it.
+// With appendTo, e.g. on --resume, an existing file is continued and numbered files start after the last one.
+func OpenRotatingFile(name string, maxSize int64, interval time.Duration, appendTo bool) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		name:     name,
+		maxSize:  maxSize,
+		interval: interval,
+		appendTo: appendTo,
+		sequence: 1,
+	}
+	if appendTo && rf.rotates() {
+		last, err := rf.lastSequence()
+		if err != nil {
+			return nil, err
+		}
+		rf.sequence = last + 1
+	}
+	return rf, rf.open()
+}
+
+func (rf *RotatingFile) open() error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if rf.appendTo {
+		// a gzip file gets another gzip member, which readers handle like one stream
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(rf.FileName(), flags, 0644)
+	if err != nil {
+		return err
+	}
+	rf.file = file
+	rf.out = file
+	rf.gz = nil
+	if strings.HasSuffix(rf.name, ".gz") {
+		rf.gz = gzip.NewWriter(file)
+		rf.out = rf.gz
+	}
+	rf.written = 0
+	rf.opened = time.Now()
+	return nil
+}
+
+func (rf *RotatingFile) rotates() bool {
+	return rf.maxSize > 0 || rf.interval > 0
+}
+
+// FileName is the name of the current file.
+func (rf *RotatingFile) FileName() string {
+	if !rf.rotates() {
+		return rf.name
+	}
+	dir, base, ext := rf.splitName()
+	return fmt.Sprintf("%v%v-%04d%v", dir, base, rf.sequence, ext)
+}
+
+func (rf *RotatingFile) splitName() (dir, base, ext string) {
+	dir, base = filepath.Split(rf.name)
+	// only the last extension is split off, so that dots in the name are kept, e.g. results.2026-10.jsonl.gz
+	name := strings.TrimSuffix(base, ".gz")
+	ext = filepath.Ext(name) + base[len(name):]
+	if len(ext) < len(base) {
+		base = base[:len(base)-len(ext)]
+	} else {
+		ext = ""
+	}
+	return dir, base, ext
+}
+
+// lastSequence returns the highest number of the existing files, or 0.
+func (rf *RotatingFile) lastSequence() (int, error) {
+	dir, base, ext := rf.splitName()
+	files, err := filepath.Glob(dir + base + "-[0-9]*" + ext)
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, file := range files {
+		var sequence int
+		number := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), base+"-"), ext)
+		if _, err := fmt.Sscanf(number, "%d", &sequence); err == nil && sequence > last {
+			last = sequence
+		}
+	}
+	return last, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	n, err := rf.out.Write(p)
+	rf.written += int64(n)
+	return n, err
+}
+
+// ShouldRotate reports whether the next n bytes should go into a new file.
+// Buffered are the bytes not yet written by a buffered writer in front of the file.
+func (rf *RotatingFile) ShouldRotate(buffered, n int) bool {
+	size := rf.written + int64(buffered)
+	if size == 0 {
+		return false
+	}
+	return (rf.maxSize > 0 && size+int64(n) > rf.maxSize) ||
+		(rf.interval > 0 && time.Since(rf.opened) >= rf.interval)
+}
+
+// Rotate closes the current file and opens the next one.
+func (rf *RotatingFile) Rotate() error {
+	if err := rf.Close(); err != nil {
+		return err
+	}
+	rf.sequence++
+	return rf.open()
+}
+
+func (rf *RotatingFile) Close() error {
+	if rf.gz != nil {
+		if err := rf.gz.Close(); err != nil {
+			rf.file.Close()
+			return err
+		}
+	}
+	return rf.file.Close()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_JSONLinesFile_RotateBySize(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	jp, err := NewJSONLinesFile(filepath.Join(dir, "results.jsonl"), 800, 0, false)
+	a.NoError(err)
+	for i := 0; i < 5; i++ {
+		a.NoError(jp.Process(context.Background(), &LogEntry{Request: "/foo"}))
+	}
+	a.NoError(jp.Finish(context.Background()))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	a.Equal([]string{
+		filepath.Join(dir, "results-0001.jsonl"),
+		filepath.Join(dir, "results-0002.jsonl"),
+		filepath.Join(dir, "results-0003.jsonl"),
+	}, files)
+
+	lines := 0
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		a.NoError(err)
+		a.True(len(content) <= 800)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			a.True(strings.HasPrefix(line, `{"Clientip"`) && strings.HasSuffix(line, "}"))
+			lines++
+		}
+	}
+	a.Equal(5, lines)
+}
+
+func Test_JSONLinesFile_Gzip(t *testing.T) {
+	a := assert.New(t)
+	name := filepath.Join(t.TempDir(), "results.jsonl.gz")
+
+	jp, err := NewJSONLinesFile(name, 0, 0, false)
+	a.NoError(err)
+	a.NoError(jp.Process(context.Background(), &LogEntry{Request: "/foo"}))
+	a.NoError(jp.Process(context.Background(), &LogEntry{Request: "/ignored", ContentType: "ignore"}))
+	a.NoError(jp.Finish(context.Background()))
+
+	file, err := os.Open(name)
+	a.NoError(err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	a.NoError(err)
+	content, err := ioutil.ReadAll(gz)
+	a.NoError(err)
+	a.Equal(1, strings.Count(string(content), "\n"))
+	a.Contains(string(content), `"Request":"/foo"`)
+}
+
+func Test_JSONLinesFile_Append(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	write := func(name string, maxSize int64, appendTo bool) {
+		jp, err := NewJSONLinesFile(filepath.Join(dir, name), maxSize, 0, appendTo)
+		a.NoError(err)
+		for i := 0; i < 5; i++ {
+			a.NoError(jp.Process(context.Background(), &LogEntry{Request: "/foo"}))
+		}
+		a.NoError(jp.Finish(context.Background()))
+	}
+
+	for _, name := range []string{"results.jsonl", "results.jsonl.gz"} {
+		write(name, 0, false)
+		write(name, 0, true)
+		file, err := os.Open(filepath.Join(dir, name))
+		a.NoError(err)
+		var content []byte
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := gzip.NewReader(file)
+			a.NoError(err)
+			content, err = ioutil.ReadAll(gz)
+			a.NoError(err)
+		} else {
+			content, err = ioutil.ReadAll(file)
+			a.NoError(err)
+		}
+		file.Close()
+		a.Equal(10, strings.Count(string(content), "\n"), name)
+	}
+
+	// numbered files continue after the last one
+	write("rotated.jsonl", 800, false)
+	write("rotated.jsonl", 800, true)
+	files, _ := filepath.Glob(filepath.Join(dir, "rotated-*"))
+	a.Equal([]string{
+		filepath.Join(dir, "rotated-0001.jsonl"),
+		filepath.Join(dir, "rotated-0002.jsonl"),
+		filepath.Join(dir, "rotated-0003.jsonl"),
+		filepath.Join(dir, "rotated-0004.jsonl"),
+		filepath.Join(dir, "rotated-0005.jsonl"),
+		filepath.Join(dir, "rotated-0006.jsonl"),
+	}, files)
+}
+
+func Test_RotatingFile_DottedNames(t *testing.T) {
+	a := assert.New(t)
+	for name, expected := range map[string]string{
+		"results.jsonl":            "results-0001.jsonl",
+		"results.jsonl.gz":         "results-0001.jsonl.gz",
+		"results.2026-10.jsonl":    "results.2026-10-0001.jsonl",
+		"results.2026-10.jsonl.gz": "results.2026-10-0001.jsonl.gz",
+		"run.v1.2":                 "run.v1-0001.2",
+		"results":                  "results-0001",
+		".jsonl":                   ".jsonl-0001",
+	} {
+		rf := &RotatingFile{name: name, maxSize: 800, sequence: 1}
+		a.Equal(expected, rf.FileName(), name)
+	}
+
+	// the numbering continues for names with dots
+	dir := t.TempDir()
+	for _, appendTo := range []bool{false, true} {
+		jp, err := NewJSONLinesFile(filepath.Join(dir, "results.2026-10.jsonl"), 800, 0, appendTo)
+		a.NoError(err)
+		for i := 0; i < 3; i++ {
+			a.NoError(jp.Process(context.Background(), &LogEntry{Request: "/foo"}))
+		}
+		a.NoError(jp.Finish(context.Background()))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	a.Equal([]string{
+		filepath.Join(dir, "results.2026-10-0001.jsonl"),
+		filepath.Join(dir, "results.2026-10-0002.jsonl"),
+		filepath.Join(dir, "results.2026-10-0003.jsonl"),
+		filepath.Join(dir, "results.2026-10-0004.jsonl"),
+	}, files)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterProcessor("sample", "replay only a part of the sessions or requests by the --sample-* options", func(args *Args) (Processor, error) {
+		return NewSamplingProcessor(args.SampleBy, args.SamplePercent, args.SampleRate)
+	})
+}
+
+const (
+	SampleBySession = "session"
+	SampleByRequest = "request"
+)
+
+// SamplingProcessor down-scales the log by marking entries as "ignore",
+// so that the following processors skip them.
+// Sampling by session keeps or drops all requests of a client ip together.
+type SamplingProcessor struct {
+	mutex      *sync.Mutex
+	by         string
+	percent    float64
+	targetRate float64
+	sessions   map[string]bool
+	firstSeen  time.Time
+	lastSeen   time.Time
+	seen       int
+	kept       int
+}
+
+// NewSamplingProcessor creates a sampler which either keeps a fixed percentage
+// of the entries or, if targetRate > 0, adjusts the probability
+// to reach targetRate requests per second of log time.
+func NewSamplingProcessor(by string, percent, targetRate float64) (*SamplingProcessor, error) {
+	if by != SampleBySession && by != SampleByRequest {
+		return nil, fmt.Errorf("unknown sampling mode %q (expected %v or %v)", by, SampleBySession, SampleByRequest)
+	}
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("sampling percentage has to be between 0 and 100, got %v", percent)
+	}
+	if targetRate < 0 {
+		return nil, fmt.Errorf("sampling rate has to be positive, got %v", targetRate)
+	}
+	return &SamplingProcessor{
+		mutex:      &sync.Mutex{},
+		by:         by,
+		percent:    percent,
+		targetRate: targetRate,
+		sessions:   make(map[string]bool),
+	}, nil
+}
+
+func (sp *SamplingProcessor) Process(ctx context.Context, l *LogEntry) error {
+	if l.ContentType == "ignore" {
+		return nil
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if sp.seen == 0 {
+		sp.firstSeen = l.Timestamp
+	}
+	sp.lastSeen = l.Timestamp
+	sp.seen++
+
+	if sp.keep(l) {
+		sp.kept++
+	} else {
+		l.ContentType = "ignore"
+	}
+	return nil
+}
+
+func (sp *SamplingProcessor) keep(l *LogEntry) bool {
+	if sp.by == SampleByRequest {
+		return l.Rand().Float64() < sp.probability()
+	}
+
+	if sp.targetRate == 0 {
+		// stateless, so that a session is sampled the same way in every run
+		return hashFraction(l.Clientip) < sp.percent/100
+	}
+
+	decision, exist := sp.sessions[l.Clientip]
+	if !exist {
+		decision = l.Rand().Float64() < sp.probability()
+		sp.sessions[l.Clientip] = decision
+	}
+	return decision
+}
+
+// probability returns the current chance for keeping an entry.
+// For a target rate it is derived from the rate observed so far.
+func (sp *SamplingProcessor) probability() float64 {
+	if sp.targetRate == 0 {
+		return sp.percent / 100
+	}
+	seconds := sp.lastSeen.Sub(sp.firstSeen).Seconds()
+	if seconds < 1 {
+		seconds = 1
+	}
+	observedRate := float64(sp.seen) / seconds
+	if observedRate <= sp.targetRate {
+		return 1
+	}
+	return sp.targetRate / observedRate
+}
+
+// ScaleFactor is the ratio between the kept and the seen entries.
+func (sp *SamplingProcessor) ScaleFactor() float64 {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return sp.scaleFactor()
+}
+
+func (sp *SamplingProcessor) scaleFactor() float64 {
+	if sp.seen == 0 {
+		return 1
+	}
+	return float64(sp.kept) / float64(sp.seen)
+}
+
+func (sp *SamplingProcessor) PrintResults(w io.Writer) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if sp.percent == 100 && sp.targetRate == 0 {
+		return
+	}
+
+	scale := sp.scaleFactor()
+	fmt.Fprintf(w, "sampling by %v: kept %v of %v entries (effective scale factor %.4f)\n", sp.by, sp.kept, sp.seen, scale)
+}
+
+func hashFraction(key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) / 10000
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_SamplingProcessor_KeepsSessionsTogether(t *testing.T) {
+	a := assert.New(t)
+
+	sp, err := NewSamplingProcessor(SampleBySession, 50, 0)
+	a.NoError(err)
+
+	decisions := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		ip := fmt.Sprintf("10.0.%v.%v", i%20, i%7)
+		l := &LogEntry{Clientip: ip, ContentType: "page"}
+		a.NoError(sp.Process(context.Background(), l))
+
+		kept := l.ContentType != "ignore"
+		if decision, exist := decisions[ip]; exist {
+			a.Equal(decision, kept, ip)
+		}
+		decisions[ip] = kept
+	}
+	a.True(sp.ScaleFactor() > 0)
+	a.True(sp.ScaleFactor() < 1)
+}
+
+func Test_SamplingProcessor_TargetRate(t *testing.T) {
+	a := assert.New(t)
+
+	sp, err := NewSamplingProcessor(SampleByRequest, 100, 10)
+	a.NoError(err)
+
+	// 100 requests per second for 100 seconds
+	start := time.Now()
+	for i := 0; i < 10000; i++ {
+		l := &LogEntry{Clientip: "1.2.3.4", ContentType: "page", Timestamp: start.Add(time.Duration(i) * 10 * time.Millisecond), seq: uint64(i + 1)}
+		a.NoError(sp.Process(context.Background(), l))
+	}
+	a.InDelta(0.1, sp.ScaleFactor(), 0.05)
+}
+
+func Test_SamplingProcessor_ReproducibleWithSeed(t *testing.T) {
+	a := assert.New(t)
+	defer func(s int64) { seed = s }(seed)
+
+	sample := func(s int64) []bool {
+		seed = s
+		sp, err := NewSamplingProcessor(SampleByRequest, 50, 0)
+		a.NoError(err)
+		kept := []bool{}
+		for i := 0; i < 100; i++ {
+			l := &LogEntry{Clientip: "1.2.3.4", ContentType: "page", seq: uint64(i + 1)}
+			a.NoError(sp.Process(context.Background(), l))
+			kept = append(kept, l.ContentType != "ignore")
+		}
+		return kept
+	}
+	a.Equal(sample(42), sample(42))
+	a.NotEqual(sample(42), sample(43))
+}
+
+func Test_SamplingProcessor_InvalidOptions(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewSamplingProcessor("foo", 50, 0)
+	a.Error(err)
+
+	_, err = NewSamplingProcessor(SampleByRequest, 150, 0)
+	a.Error(err)
+}
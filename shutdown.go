@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals calls stop on the first SIGINT or SIGTERM,
+// so that the queued entries are still processed and the results printed.
+// A second signal exits immediately.
+func handleSignals(stop context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintf(os.Stderr, "stopping, waiting up to %v for queued entries (signal again to exit immediately)\n", args.GracePeriod)
+		stop()
+		<-signals
+		fmt.Fprintf(os.Stderr, "exit without results\n")
+		os.Exit(1)
+	}()
+}
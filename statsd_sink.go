@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterProcessor("statsd", "send timers and counters per content type to statsd at --statsd", func(args *Args) (Processor, error) {
+		if args.StatsdAddr == "" {
+			return nil, errors.New("no --statsd address given")
+		}
+		return NewStatsDSink(StatsDOptions{
+			Address:       args.StatsdAddr,
+			Prefix:        args.StatsdPrefix,
+			DogStatsD:     args.DogStatsD,
+			FlushInterval: args.MetricsFlush,
+		})
+	})
+}
+
+// maxStatsDPacket keeps the datagrams below the usual network mtu.
+const maxStatsDPacket = 1432
+
+type StatsDOptions struct {
+	// Address as host:port of the udp listener
+	Address string
+	Prefix  string
+	// DogStatsD sends the content type, verb and status as tags instead of in the metric names
+	DogStatsD     bool
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// StatsDSink sends a timer and counters per replayed entry,
+// e.g. replaybench.page.duration:12|ms and replaybench.page.status.200:1|c.
+// Several metrics are packed into one datagram.
+type StatsDSink struct {
+	*BatchSink
+	options StatsDOptions
+	conn    net.Conn
+}
+
+func NewStatsDSink(options StatsDOptions) (*StatsDSink, error) {
+	if options.Prefix == "" {
+		options.Prefix = "replaybench"
+	}
+	options.Prefix = strings.TrimSuffix(options.Prefix, ".")
+	conn, err := net.Dial("udp", options.Address)
+	if err != nil {
+		return nil, fmt.Errorf("can not connect to statsd at %v: %v", options.Address, err)
+	}
+	ss := &StatsDSink{
+		options: options,
+		conn:    conn,
+	}
+	ss.BatchSink = NewBatchSink("statsd", BatchOptions{BatchSize: options.BatchSize, FlushInterval: options.FlushInterval}, ss.send)
+	return ss, nil
+}
+
+// send returns the number of entries, which metrics were all written before an error.
+func (ss *StatsDSink) send(ctx context.Context, batch []*LogEntry) (int, error) {
+	packet := &bytes.Buffer{}
+	sent, packed := 0, 0
+	flush := func() error {
+		if _, err := ss.conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+		packet.Reset()
+		sent += packed
+		packed = 0
+		return nil
+	}
+	for _, l := range batch {
+		for _, metric := range ss.metrics(l) {
+			if packet.Len() > 0 && packet.Len()+1+len(metric) > maxStatsDPacket {
+				if err := flush(); err != nil {
+					return sent, err
+				}
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(metric)
+		}
+		packed++
+	}
+	if packet.Len() > 0 {
+		if err := flush(); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func (ss *StatsDSink) metrics(l *LogEntry) []string {
+	r := l.Replay
+	contentType := statsdName(l.ContentType)
+	status := strconv.Itoa(r.Status)
+	if ss.options.DogStatsD {
+		tags := fmt.Sprintf("|#content_type:%v,verb:%v,status:%v", contentType, statsdName(l.Verb), status)
+		metrics := []string{
+			fmt.Sprintf("%v.duration:%v|ms%v", ss.options.Prefix, r.DurationMs, tags),
+			fmt.Sprintf("%v.requests:1|c%v", ss.options.Prefix, tags),
+		}
+		if r.Error {
+			metrics = append(metrics, fmt.Sprintf("%v.errors:1|c%v,error_category:%v", ss.options.Prefix, tags, statsdName(string(r.ErrorCategory))))
+		}
+		return metrics
+	}
+
+	prefix := ss.options.Prefix + "." + contentType
+	metrics := []string{
+		fmt.Sprintf("%v.duration:%v|ms", prefix, r.DurationMs),
+		fmt.Sprintf("%v.requests:1|c", prefix),
+		fmt.Sprintf("%v.status.%v:1|c", prefix, status),
+	}
+	if r.Error {
+		metrics = append(metrics, fmt.Sprintf("%v.errors.%v:1|c", prefix, statsdName(string(r.ErrorCategory))))
+	}
+	return metrics
+}
+
+// statsdName replaces the characters with a meaning in the statsd protocol.
+func statsdName(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Finish sends the queued metrics and closes the socket.
+func (ss *StatsDSink) Finish(ctx context.Context) error {
+	defer ss.conn.Close()
+	return ss.BatchSink.Finish(ctx)
+}
+
+func (ss *StatsDSink) PrintResults(w io.Writer) {
+	sent, failed := ss.counts()
+	fmt.Fprintf(w, "sent to statsd: %v\n", sent)
+	if failed > 0 {
+		fmt.Fprintf(w, "failed to send to statsd: %v\n", failed)
+	}
+}
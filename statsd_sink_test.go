@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_StatsDSink(t *testing.T) {
+	a := assert.New(t)
+	tests := []struct {
+		dogStatsD bool
+		category  ErrorCategory
+		expected  []string
+	}{
+		{false, ErrorStatusMismatch, []string{
+			"replaybench.page.duration:12|ms",
+			"replaybench.page.requests:1|c",
+			"replaybench.page.status.500:1|c",
+			"replaybench.page.errors.status_mismatch:1|c",
+		}},
+		{true, ErrorStatusMismatch, []string{
+			"replaybench.duration:12|ms|#content_type:page,verb:GET,status:500",
+			"replaybench.requests:1|c|#content_type:page,verb:GET,status:500",
+			"replaybench.errors:1|c|#content_type:page,verb:GET,status:500,error_category:status_mismatch",
+		}},
+		{true, "body,json:mismatch", []string{
+			"replaybench.duration:12|ms|#content_type:page,verb:GET,status:500",
+			"replaybench.requests:1|c|#content_type:page,verb:GET,status:500",
+			"replaybench.errors:1|c|#content_type:page,verb:GET,status:500,error_category:body_json_mismatch",
+		}},
+	}
+	for _, test := range tests {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		a.NoError(err)
+		defer conn.Close()
+
+		ss, err := NewStatsDSink(StatsDOptions{Address: conn.LocalAddr().String(), DogStatsD: test.dogStatsD})
+		a.NoError(err)
+		l := &LogEntry{Request: "/a", ContentType: "page", Verb: "GET"}
+		l.Replay.Status = 500
+		l.Replay.DurationMs = 12
+		l.Replay.setError(test.category, "500")
+		a.NoError(ss.Process(context.Background(), l))
+		a.NoError(ss.Finish(context.Background()))
+
+		buff := make([]byte, maxStatsDPacket)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buff)
+		a.NoError(err)
+		a.Equal(test.expected, strings.Split(string(buff[:n]), "\n"))
+	}
+}
+
+func Test_StatsDSink_SplitsPackets(t *testing.T) {
+	a := assert.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.NoError(err)
+	defer conn.Close()
+
+	ss, err := NewStatsDSink(StatsDOptions{Address: conn.LocalAddr().String()})
+	a.NoError(err)
+	for i := 0; i < 100; i++ {
+		l := &LogEntry{ContentType: "asset"}
+		l.Replay.Status = 200
+		a.NoError(ss.Process(context.Background(), l))
+	}
+	a.NoError(ss.Finish(context.Background()))
+
+	metrics := 0
+	buff := make([]byte, 65535)
+	for metrics < 300 {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buff)
+		if !a.NoError(err) {
+			return
+		}
+		a.True(n <= maxStatsDPacket)
+		metrics += len(strings.Split(string(buff[:n]), "\n"))
+	}
+	a.Equal(300, metrics)
+}
+
+type failingConn struct {
+	net.Conn
+	writes  int
+	packets []string
+}
+
+func (fc *failingConn) Write(p []byte) (int, error) {
+	if len(fc.packets) == fc.writes {
+		return 0, errors.New("write failed")
+	}
+	fc.packets = append(fc.packets, string(p))
+	return len(p), nil
+}
+
+func Test_StatsDSink_CountsWrittenEntries(t *testing.T) {
+	a := assert.New(t)
+	conn := &failingConn{writes: 1}
+	ss := &StatsDSink{options: StatsDOptions{Prefix: "replaybench"}, conn: conn}
+	batch := []*LogEntry{}
+	for i := 0; i < 100; i++ {
+		l := &LogEntry{ContentType: "asset"}
+		l.Replay.Status = 200
+		batch = append(batch, l)
+	}
+
+	sent, err := ss.send(context.Background(), batch)
+	a.Error(err)
+	a.Equal(1, len(conn.packets))
+	// an entry, which metrics are split over the failed packet, isn't counted
+	a.Equal(len(strings.Split(conn.packets[0], "\n"))/3, sent)
+	a.True(sent > 0)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+)
+
+const (
+	TracePerRequest = "request"
+	TracePerSession = "session"
+)
+
+// TraceContext sets the w3c trace context headers (and optionally b3) on the replayed requests,
+// so that the backend traces can be found by the TraceId of an entry.
+// Per session, all requests of a simulated user share one trace id and are sent as children of one session span.
+type TraceContext struct {
+	mode  string
+	state string
+	b3    bool
+	// nonce of the run is mixed into the ids, so that runs with the same seed don't share traces
+	nonce []byte
+}
+
+// NewTraceContext returns nil, if the mode is empty.
+func NewTraceContext(mode, state string, b3 bool) (*TraceContext, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case TracePerRequest, TracePerSession:
+		nonce := make([]byte, 16)
+		if _, err := crand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return &TraceContext{mode: mode, state: state, b3: b3, nonce: nonce}, nil
+	}
+	return nil, fmt.Errorf("unknown trace context %q, use %v or %v", mode, TracePerRequest, TracePerSession)
+}
+
+// session returns the trace id and span id for the requests of a user session starting with the entry,
+// or empty ids if every request gets its own trace.
+func (tc *TraceContext) session(first *LogEntry) (traceId, spanId string) {
+	if tc.mode != TracePerSession {
+		return "", ""
+	}
+	return tc.randomHex(first.Rand(), 16), tc.randomHex(first.Rand(), 8)
+}
+
+// start sets the ids of the replayed request: a new trace and span,
+// or the trace of the session with the session span as parent.
+func (tc *TraceContext) start(l *LogEntry, sessionTraceId, sessionSpanId string) {
+	if sessionTraceId == "" {
+		l.TraceId = tc.randomHex(l.Rand(), 16)
+		l.SpanId = tc.randomHex(l.Rand(), 8)
+		return
+	}
+	l.TraceId = sessionTraceId
+	l.ParentSpanId = sessionSpanId
+}
+
+// Apply sets the headers for the span of the entry, always as sampled.
+// The parent id is the span of the request or of the session.
+func (tc *TraceContext) Apply(request *http.Request, l *LogEntry) {
+	parentId := l.SpanId
+	if l.ParentSpanId != "" {
+		parentId = l.ParentSpanId
+	}
+	request.Header.Set("Traceparent", fmt.Sprintf("00-%v-%v-01", l.TraceId, parentId))
+	if tc.state != "" {
+		request.Header.Set("Tracestate", tc.state)
+	}
+	if tc.b3 {
+		request.Header.Set("B3", fmt.Sprintf("%v-%v-1", l.TraceId, parentId))
+	}
+}
+
+// randomHex returns n random bytes mixed with the nonce and hex encoded,
+// which are never all zero (invalid in the trace context).
+func (tc *TraceContext) randomHex(r *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for {
+		r.Read(b)
+		for i := range b {
+			b[i] ^= tc.nonce[i%len(tc.nonce)]
+		}
+		for _, c := range b {
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
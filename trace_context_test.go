@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func Test_TraceContext_PerRequest(t *testing.T) {
+	a := assert.New(t)
+	tc, err := NewTraceContext(TracePerRequest, "replaybench=1", true)
+	a.NoError(err)
+
+	l1, l2 := &LogEntry{}, &LogEntry{}
+	l1.seq, l2.seq = 1, 2
+	traceId, spanId := tc.session(&LogEntry{seq: 1})
+	a.Equal("", traceId)
+	tc.start(l1, traceId, spanId)
+	tc.start(l2, traceId, spanId)
+	a.Regexp(`^[0-9a-f]{32}$`, l1.TraceId)
+	a.Regexp(`^[0-9a-f]{16}$`, l1.SpanId)
+	a.NotEqual(l1.TraceId, l2.TraceId)
+
+	request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	tc.Apply(request, l1)
+	a.Equal("00-"+l1.TraceId+"-"+l1.SpanId+"-01", request.Header.Get("traceparent"))
+	a.Equal("replaybench=1", request.Header.Get("tracestate"))
+	a.Equal(l1.TraceId+"-"+l1.SpanId+"-1", request.Header.Get("b3"))
+}
+
+func Test_TraceContext_PerSession(t *testing.T) {
+	a := assert.New(t)
+	tc, err := NewTraceContext(TracePerSession, "", false)
+	a.NoError(err)
+
+	l1, l2 := &LogEntry{seq: 1}, &LogEntry{seq: 2}
+	traceId, spanId := tc.session(l1)
+	otherTraceId, _ := tc.session(&LogEntry{seq: 3})
+	a.NotEqual(traceId, otherTraceId)
+	tc.start(l1, traceId, spanId)
+	tc.start(l2, traceId, spanId)
+	a.Equal(traceId, l1.TraceId)
+	a.Equal(traceId, l2.TraceId)
+	a.Equal(spanId, l1.ParentSpanId)
+	a.Equal(spanId, l2.ParentSpanId)
+
+	request, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	tc.Apply(request, l2)
+	a.True(regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(request.Header.Get("traceparent")))
+	a.Equal("00-"+traceId+"-"+spanId+"-01", request.Header.Get("traceparent"))
+	a.Equal("", request.Header.Get("tracestate"))
+	a.Equal("", request.Header.Get("b3"))
+}
+
+func Test_TraceContext_UniquePerRun(t *testing.T) {
+	a := assert.New(t)
+	run1, err := NewTraceContext(TracePerSession, "", false)
+	a.NoError(err)
+	run2, err := NewTraceContext(TracePerSession, "", false)
+	a.NoError(err)
+
+	// the same entry with the same seed
+	traceId1, _ := run1.session(&LogEntry{seq: 1})
+	traceId2, _ := run2.session(&LogEntry{seq: 1})
+	a.NotEqual(traceId1, traceId2)
+}
+
+func Test_TraceContext_Off(t *testing.T) {
+	a := assert.New(t)
+	tc, err := NewTraceContext("", "", false)
+	a.NoError(err)
+	a.Nil(tc)
+
+	_, err = NewTraceContext("always", "", false)
+	a.Error(err)
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -11,43 +11,42 @@ import (
 )
 
 type UserSimulation struct {
-	baseURL      string
-	username     string
-	password     string
-	fanout       chan *LogEntry
-	mux          *sync.Mutex
-	shouldFinish chan bool
-	workerDone   []chan bool
-	log          Processor
-	lastAction   time.Time
+	user       string
+	options    ReplayOptions
+	ctx        context.Context
+	fanout     chan *LogEntry
+	mux        *sync.Mutex
+	workers    *sync.WaitGroup
+	lastAction time.Time
+	traceId    string
+	spanId     string
 }
 
-var redirectError = errors.New("redirect")
-
-func init() {
-	rand.Seed(time.Now().UTC().UnixNano())
-}
-
-func newUserSimulation(baseURL string, log Processor, username, password string) *UserSimulation {
+// newUserSimulation starts the workers of a user.
+// Canceling the context aborts the running requests.
+func newUserSimulation(ctx context.Context, user string, options ReplayOptions) *UserSimulation {
 	us := &UserSimulation{
-		baseURL:      baseURL,
-		username:     username,
-		password:     password,
-		fanout:       make(chan *LogEntry, 10),
-		mux:          &sync.Mutex{},
-		shouldFinish: make(chan bool),
-		workerDone:   make([]chan bool, 6),
-		log:          log,
-		lastAction:   time.Now(),
-	}
-	for i, _ := range us.workerDone {
-		us.workerDone[i] = make(chan bool)
-		go us.startWorker(us.shouldFinish, us.workerDone[i])
+		user:       user,
+		options:    options,
+		ctx:        ctx,
+		fanout:     make(chan *LogEntry, 10),
+		mux:        &sync.Mutex{},
+		workers:    &sync.WaitGroup{},
+		lastAction: time.Now(),
+	}
+	client := options.Client.NewClient()
+	for i := 0; i < 6; i++ {
+		us.workers.Add(1)
+		go us.startWorker(client)
 	}
 	return us
 }
 
 func (us *UserSimulation) Process(l *LogEntry) error {
+	if us.options.Trace != nil && us.traceId == "" {
+		// the workers see the ids through the channel
+		us.traceId, us.spanId = us.options.Trace.session(l)
+	}
 	if l.Verb != "GET" {
 		l.wg.Done()
 		return nil
@@ -64,60 +63,123 @@ func (us *UserSimulation) IsActive() bool {
 
 func (us *UserSimulation) doCall(client *http.Client, l *LogEntry) {
 	us.UpdateLastAction()
+	defer us.UpdateLastAction()
 
 	l.Timestamp = time.Now()
-	l.CorrelationId = "rep-" + randStringBytes(10)
+	l.CorrelationId = "rep-" + randStringBytes(l.Rand(), 10)
+	if us.options.Trace != nil {
+		us.options.Trace.start(l, us.traceId, us.spanId)
+	}
 
-	url := us.baseURL + l.Request
-	request, err := http.NewRequest("GET", url, nil)
-	request.Header.Set("X-Correlation-Id", l.CorrelationId)
-	if us.username != "" {
-		request.SetBasicAuth(us.username, us.password)
+	if l.Replay.Target == "" {
+		l.Replay.Target = us.options.BaseURL
 	}
-	if err != nil {
-		l.Replay.Error = true
-		l.Replay.ErrorMessage = err.Error()
+	if us.options.CandidateURL == "" {
+		us.call(client, l, &l.Replay.ReplayResult)
 		return
 	}
+
+	// call both targets at the same time, so that they see the same load
+	l.Replay.Candidate = &CandidateResult{}
+	l.Replay.Candidate.Target = us.options.CandidateURL
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	var referenceBody, candidateBody []byte
+	go func() {
+		defer wg.Done()
+		referenceBody = us.call(client, l, &l.Replay.ReplayResult)
+	}()
+	go func() {
+		defer wg.Done()
+		candidateBody = us.call(client, l, &l.Replay.Candidate.ReplayResult)
+	}()
+	wg.Wait()
+
+	l.Replay.Candidate.StatusMismatch = l.Replay.Status != l.Replay.Candidate.Status
+	l.Replay.Candidate.DurationDiffMs = l.Replay.Candidate.DurationMs - l.Replay.DurationMs
+	if us.options.BodyComparer != nil && l.Replay.Status != 0 && !l.Replay.Candidate.StatusMismatch {
+		l.Replay.Candidate.BodyDiff = us.options.BodyComparer.Diff(referenceBody, candidateBody)
+		l.Replay.Candidate.BodyMismatch = l.Replay.Candidate.BodyDiff != ""
+	}
+}
+
+// call requests the entry from the target of the result.
+// It returns the response body only, if bodies have to be compared.
+func (us *UserSimulation) call(client *http.Client, l *LogEntry, result *ReplayResult) []byte {
+	start := time.Now()
+
+	url := result.Target + l.Request
+	request, err := http.NewRequestWithContext(us.ctx, "GET", url, nil)
+	if err != nil {
+		result.setError(ErrorInvalidRequest, err.Error())
+		return nil
+	}
+	request.Header.Set("X-Correlation-Id", l.CorrelationId)
+	if us.options.Trace != nil {
+		us.options.Trace.Apply(request, l)
+	}
+	if us.options.Auth != nil {
+		if err := us.options.Auth.Authenticate(request, us.user); err != nil {
+			result.setError(ErrorAuth, err.Error())
+			return nil
+		}
+	}
+	if us.options.PreserveHost && l.Host != "" {
+		request.Host = l.Host
+	}
+	if us.options.Headers != nil {
+		if err := us.options.Headers.Apply(request, l); err != nil {
+			result.setError(ErrorInvalidRequest, err.Error())
+			return nil
+		}
+	}
+	trace, request := newRequestTrace(request)
 	resp, err := client.Do(request)
-	if err != nil && !(err == redirectError && (l.Response == 301 || l.Response == 302 || l.Response == 303)) {
-		l.Replay.Error = true
-		l.Replay.ErrorMessage = fmt.Sprintf("expected %v, but got redirect: %e", l.Response, err)
-		return
+	trace.fill(result)
+	if err != nil {
+		result.DurationMs = int(time.Since(start).Nanoseconds() / 1000000)
+		result.setError(classifyError(err), err.Error())
+		return nil
 	}
-	ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
-	defer us.UpdateLastAction()
 
-	l.Replay.ErrorMessage = fmt.Sprintf("%v", resp.StatusCode)
-	l.Replay.DurationMs = int(time.Since(l.Timestamp).Nanoseconds() / 1000000)
+	result.Status = resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	result.DurationMs = int(time.Since(start).Nanoseconds() / 1000000)
+	result.ResponseBytes = len(body)
+	result.ContentEncoding = resp.Header.Get("Content-Encoding")
+	if resp.Uncompressed {
+		// the transport has decoded the body transparently
+		result.ContentEncoding = "gzip"
+	}
+
+	if err != nil {
+		result.setError(ErrorBodyRead, fmt.Sprintf("error reading body: %v", err))
+		return nil
+	}
 	if resp.StatusCode != l.Response {
-		l.Replay.Error = true
-		l.Replay.ErrorMessage = fmt.Sprintf("Wrong status returned: %v (expected: %v)", resp.StatusCode, l.Response)
-		return
+		if isRedirect(resp.StatusCode) {
+			result.setError(ErrorUnexpectedRedirect, fmt.Sprintf("expected %v, but got redirect %v to %v", l.Response, resp.StatusCode, resp.Header.Get("Location")))
+		} else {
+			result.setError(ErrorStatusMismatch, fmt.Sprintf("Wrong status returned: %v (expected: %v)", resp.StatusCode, l.Response))
+		}
+	}
+	if us.options.BodyComparer == nil {
+		return nil
 	}
+	return body
 }
 
-func (us *UserSimulation) startWorker(shouldFinishC, done chan bool) {
-	client := &http.Client{Timeout: time.Second * 10}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return redirectError
-	}
-loop:
-	for {
-		select {
-		case l := <-us.fanout:
-			if l.ContentType == "ignore" {
-				l.wg.Done()
-				continue
-			}
+func (us *UserSimulation) startWorker(client *http.Client) {
+	defer us.workers.Done()
+	for l := range us.fanout {
+		if us.ctx.Err() != nil {
+			l.Replay.setError(ErrorCanceled, "replay canceled")
+		} else {
 			us.doCall(client, l)
-			l.wg.Done()
-		case <-shouldFinishC:
-			break loop
 		}
+		l.wg.Done()
 	}
-	done <- true
 }
 
 func (us *UserSimulation) UpdateLastAction() {
@@ -126,27 +188,18 @@ func (us *UserSimulation) UpdateLastAction() {
 	us.lastAction = time.Now()
 }
 
-func (us *UserSimulation) Finish() chan bool {
-	done := make(chan bool)
-	go func() {
-		for len(us.fanout) > 0 {
-			time.Sleep(10 * time.Millisecond)
-		}
-		close(us.shouldFinish) // close does a broadcast
-		for _, c := range us.workerDone {
-			<-c
-		}
-		done <- true
-	}()
-	return done
+// Finish waits until the queued entries are replayed.
+func (us *UserSimulation) Finish() {
+	close(us.fanout)
+	us.workers.Wait()
 }
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-func randStringBytes(n int) string {
+func randStringBytes(r *rand.Rand, n int) string {
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+		b[i] = letterBytes[r.Intn(len(letterBytes))]
 	}
 	return string(b)
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_UserSimulation_CallErrorCategories(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/login", 302)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/missing":
+			w.WriteHeader(404)
+		default:
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	closedURL := "http://" + listener.Addr().String()
+	listener.Close()
+
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	us := &UserSimulation{ctx: context.Background()}
+
+	tests := []struct {
+		target   string
+		request  string
+		response int
+		category ErrorCategory
+	}{
+		{server.URL, "/", 200, ""},
+		{server.URL, "/redirect", 302, ""},
+		{server.URL, "/redirect", 200, ErrorUnexpectedRedirect},
+		{server.URL, "/missing", 200, ErrorStatusMismatch},
+		{server.URL, "/slow", 200, ErrorTimeout},
+		{closedURL, "/", 200, ErrorConnectionRefused},
+		{"http://invalid.invalid", "/", 200, ErrorDNS},
+		{"http://[::1", "/", 200, ErrorInvalidRequest},
+	}
+	for _, test := range tests {
+		l := &LogEntry{Request: test.request, Response: test.response}
+		result := &ReplayResult{Target: test.target}
+		us.call(client, l, result)
+
+		a.Equal(test.category, result.ErrorCategory, "%v%v", test.target, test.request)
+		a.Equal(test.category != "", result.Error, "%v%v", test.target, test.request)
+		if test.category == "" {
+			a.Equal("", result.ErrorMessage)
+			a.Equal(test.response, result.Status)
+		}
+	}
+}
+
+func Test_UserSimulation_CallCanceled(t *testing.T) {
+	a := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	us := &UserSimulation{ctx: ctx}
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	result := &ReplayResult{Target: server.URL}
+	us.call(http.DefaultClient, &LogEntry{Request: "/", Response: 200}, result)
+
+	a.Equal(ErrorCanceled, result.ErrorCategory)
+	a.True(time.Since(start) < time.Second)
+}
+
+func Test_UserSimulation_TraceContextPerSession(t *testing.T) {
+	a := assert.New(t)
+
+	traceparents := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("Traceparent")
+	}))
+	defer server.Close()
+
+	trace, err := NewTraceContext(TracePerSession, "", false)
+	a.NoError(err)
+	us := &UserSimulation{ctx: context.Background(), mux: &sync.Mutex{}, options: ReplayOptions{BaseURL: server.URL, Trace: trace}}
+	l1 := &LogEntry{Request: "/a", Response: 200, seq: 1}
+	l2 := &LogEntry{Request: "/b", Response: 200, seq: 2}
+	us.traceId, us.spanId = trace.session(l1)
+	us.doCall(server.Client(), l1)
+	us.doCall(server.Client(), l2)
+
+	a.Equal("00-"+us.traceId+"-"+us.spanId+"-01", <-traceparents)
+	a.Equal("00-"+us.traceId+"-"+us.spanId+"-01", <-traceparents)
+	a.Equal(us.traceId, l1.TraceId)
+	a.Equal(us.traceId, l2.TraceId)
+	a.Equal(us.spanId, l1.ParentSpanId)
+	a.Equal(us.spanId, l2.ParentSpanId)
+	a.False(l1.Replay.Error)
+}